@@ -1,7 +1,6 @@
 package app
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"dbc-node/messages"
 	"dbc-node/modules"
@@ -9,9 +8,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	tendermint "github.com/tendermint/tendermint/abci/types"
+	dbm "github.com/tendermint/tm-db"
+	"strconv"
+	"sync"
 )
 
-// TODO: ZKP in payload acceptance and maybe validation
 // TODO: refactoring, better tests
 // TODO: cosmos sdk integration
 // TODO: ethereum integration
@@ -19,50 +20,98 @@ import (
 type DataBlockChain struct {
 	Height    int64
 	Proposer  []byte
-	Confirmed []state // written at 2nd commit
-	Committed state   // written at 1st commit
-	New       state   // written at deliverTx
+	Time      int64 // time of the current block, unix seconds
+	Committed state // written at commit, persisted in db
+	New       state // written at deliverTx
+	Check     state // written at checkTx, reset at commit
+	db        dbm.DB
+	mutex     sync.RWMutex // guards Committed for readers outside of ABCI connections, like the blob store
 }
 
 type state struct {
 	Dataset *modules.Dataset
 	Balance *modules.Balance
-}
-
-func (state state) hash() []byte {
-	return append(state.Dataset.Hash(), state.Balance.Hash()...)
+	index   *index            // of the committed state only
+	leaves  map[string][]byte // items of the committed state only, proven by queries
+	appHash []byte            // of the committed state only
 }
 
 var _ tendermint.Application = (*DataBlockChain)(nil)
 
-func NewDataBlockChain(genUsers, genValidators map[string]int64) *DataBlockChain {
-	balance := modules.NewBalance(&modules.Balance{
-		Users:      genUsers,
-		Validators: genValidators,
-	})
-	dataset := modules.NewDataset(&modules.Dataset{}, balance)
-	state := state{
+func (old state) next() state {
+	balance := modules.NewBalance(old.Balance)
+	dataset := modules.NewDataset(old.Dataset, balance)
+	return state{
 		Dataset: dataset,
 		Balance: balance,
 	}
+}
+
+// Resumes from the last committed state in db, or waits for InitChain to load the genesis state if db is empty
+func NewDataBlockChain(db dbm.DB) (*DataBlockChain, error) {
+	height, time, err := loadHeight(db)
+	if err != nil {
+		return nil, err
+	}
+	if height > 0 {
+		committed, err := loadState(db, height)
+		if err != nil {
+			return nil, err
+		}
+		committed.index = newIndex(committed.Dataset)
+		committed.leaves = committed.items()
+		committed.appHash = committed.hash()
+		dbc := &DataBlockChain{
+			Height:    height,
+			Time:      time,
+			Committed: committed,
+			New:       committed.next(),
+			db:        db,
+		}
+		dbc.resetCheck()
+		return dbc, nil
+	}
+	state, err := (&GenesisState{}).load("")
+	if err != nil {
+		return nil, err
+	}
 	return &DataBlockChain{
 		Height: 0,
 		New:    state,
-	}
+		Check:  state.next(),
+		db:     db,
+	}, nil
+}
+
+// Rebuilds the check state on top of the committed one, at commit and at restart
+func (dbc *DataBlockChain) resetCheck() {
+	dbc.Check = dbc.Committed.next()
+	dbc.Check.Dataset.BeginBlock(dbc.Height+1, dbc.Time) // checkTx runs ahead of the next block
+	dbc.Check.Balance.Events()                           // expiry events are reported by the deliver state only
 }
 
-func (dbc *DataBlockChain) stateAtHeight(height int) state {
-	if dbc.Confirmed == nil {
-		return state{}
+// Returns the committed state at the height, an error if it's in the future or was pruned
+func (dbc *DataBlockChain) stateAtHeight(height int64) (state, int64, error) {
+	if height == 0 || height == dbc.Height { // return state at current height: last committed state
+		return dbc.Committed, dbc.Height, nil
+	}
+	if height < 0 || height > dbc.Height {
+		return state{}, height, modules.ErrInvalidArgument.Wrap("height " + strconv.FormatInt(height, 10))
 	}
-	switch height {
-	case 0: // return dataset at current height: last confirmed state
-		return dbc.Confirmed[len(dbc.Confirmed)-1]
-	case 1: // at height 1 there's no confirmed state
-		return state{}
-	default: // confirmed state start at height 2
-		return dbc.Confirmed[height-2]
+	state, err := loadState(dbc.db, height)
+	return state, height, err
+}
+
+// Returns the size of the off-chain content with the given hash referenced by the committed state,
+// safe to call concurrently with the ABCI connections
+func (dbc *DataBlockChain) ContentSize(hash []byte) (int64, bool) {
+	dbc.mutex.RLock()
+	committed := dbc.Committed // committed states are never changed
+	dbc.mutex.RUnlock()
+	if committed.Dataset == nil {
+		return 0, false
 	}
+	return committed.Dataset.ContentSize(hash)
 }
 
 func (dbc *DataBlockChain) Info(requestInfo tendermint.RequestInfo) tendermint.ResponseInfo {
@@ -85,149 +134,145 @@ func (dbc *DataBlockChain) SetOption(requestSetOption tendermint.RequestSetOptio
 	return responseSetOption
 }
 
-func (dbc *DataBlockChain) Query(requestQuery tendermint.RequestQuery) tendermint.ResponseQuery {
-	data := make([]byte, base64.StdEncoding.DecodedLen(len(requestQuery.Data)))
-	_, _ = base64.StdEncoding.Decode(data, requestQuery.Data)
-	data = bytes.Trim(data, "\x00")
-	var query messages.Query
-	_ = json.Unmarshal(data, &query)
-	var value []byte
-	state := dbc.stateAtHeight(int(requestQuery.Height))
-	switch query.QrType {
-	case messages.QueryDataset:
-		value, _ = json.Marshal(state.Dataset)
-	case messages.QueryData:
-		value, _ = json.Marshal(state.Dataset.DataList[query.DataIndex])
-	case messages.QueryVersion:
-		value, _ = json.Marshal(state.Dataset.DataList[query.DataIndex].VersionList[query.VersionIndex])
-	case messages.QueryDescription:
-		value, _ = json.Marshal(state.Dataset.DataList[query.DataIndex].Description)
-	case messages.QueryValidation:
-		value, _ = json.Marshal(state.Dataset.DataList[query.DataIndex].VersionList[query.VersionIndex].Validation)
-	case messages.QueryPayload:
-		value, _ = json.Marshal(state.Dataset.DataList[query.DataIndex].VersionList[query.VersionIndex].Payload)
-	case messages.QueryAcceptedPayload:
-		value, _ = json.Marshal(state.Dataset.DataList[query.DataIndex].VersionList[query.VersionIndex].AcceptedPayload)
-	case messages.QueryBalance:
-		value, _ = json.Marshal(state.Balance.Users)
-	case messages.QueryStake:
-		value, _ = json.Marshal(state.Balance.Validators)
-	}
-	responseQuery := tendermint.ResponseQuery{
-		Code:      uint32(0),
-		Log:       "",
-		Info:      "",
-		Index:     -1,
-		Key:       requestQuery.Data,
-		Value:     value,
-		Proof:     nil,
-		Height:    0,
-		Codespace: "",
-	}
-	return responseQuery
-}
-
+// Runs the transaction against the check state, so pending transactions in the mempool count against the sender's balance
 func (dbc *DataBlockChain) CheckTx(requestCheckTx tendermint.RequestCheckTx) tendermint.ResponseCheckTx {
+	events, err := dbc.deliver(dbc.Check, requestCheckTx.Tx)
+	code, codespace, feedback := response(err, "transaction checked successfully")
 	responseCheckTx := tendermint.ResponseCheckTx{
-		Code:      uint32(0),
+		Code:      code,
 		Data:      nil,
-		Log:       "",
-		Info:      "",
+		Log:       feedback,
+		Info:      feedback,
 		GasWanted: 0,
 		GasUsed:   0,
-		Events:    nil,
-		Codespace: "",
+		Events:    events,
+		Codespace: codespace,
 	}
 	return responseCheckTx
 }
 
+// Loads the genesis app_state, the chain can't start from an invalid one
 func (dbc *DataBlockChain) InitChain(requestInitChain tendermint.RequestInitChain) tendermint.ResponseInitChain {
+	genesis, err := ParseGenesisState(requestInitChain.AppStateBytes)
+	if err == nil {
+		err = genesis.checkValidators(requestInitChain.Validators)
+	}
+	var initial state
+	if err == nil {
+		initial, err = genesis.load(requestInitChain.ChainId)
+	}
+	if err != nil {
+		panic(err)
+	}
+	dbc.New = initial
+	dbc.Check = initial.next()
 	responseInitChain := tendermint.ResponseInitChain{
 		ConsensusParams: nil,
-		Validators:      nil,
+		Validators:      genesis.Validators(),
 	}
 	return responseInitChain
 }
 
 func (dbc *DataBlockChain) BeginBlock(requestBeginBlock tendermint.RequestBeginBlock) tendermint.ResponseBeginBlock {
 	dbc.Proposer = requestBeginBlock.Header.ProposerAddress
+	dbc.Time = requestBeginBlock.Header.Time.Unix()
+	dbc.New.Dataset.BeginBlock(requestBeginBlock.Header.Height, dbc.Time)
 	responseBeginBlock := tendermint.ResponseBeginBlock{
-		Events: nil,
+		Events: dbc.New.Balance.Events(),
 	}
 	return responseBeginBlock
 }
 
 func (dbc *DataBlockChain) DeliverTx(requestDeliverTx tendermint.RequestDeliverTx) tendermint.ResponseDeliverTx {
-	tx := make([]byte, base64.StdEncoding.DecodedLen(len(requestDeliverTx.Tx)))
-	_, _ = base64.StdEncoding.Decode(tx, requestDeliverTx.Tx)
-	tx = bytes.Trim(tx, "\x00")
+	events, err := dbc.deliver(dbc.New, requestDeliverTx.Tx)
+	code, codespace, feedback := response(err, "transaction delivered successfully")
+	responseDeliverTx := tendermint.ResponseDeliverTx{
+		Code:      code,
+		Data:      nil,
+		Log:       feedback,
+		Info:      feedback,
+		GasWanted: 0,
+		GasUsed:   0,
+		Events:    events,
+		Codespace: codespace,
+	}
+	return responseDeliverTx
+}
+
+// Returns code, codespace and log of a transaction response
+func response(err error, success string) (uint32, string, string) {
+	if err != nil {
+		return modules.ErrorCode(err), modules.Codespace, err.Error()
+	}
+	return 0, "", success
+}
+
+// Applies the transaction to the given state and returns the events it emitted, used by both checkTx and deliverTx
+func (dbc *DataBlockChain) deliver(state state, encodedTx []byte) ([]tendermint.Event, error) {
+	err := dbc.apply(state, encodedTx)
+	events := state.Balance.Events()
+	if err != nil {
+		return nil, err // a failed transaction changes nothing but the fee, its events are dropped
+	}
+	return events, nil
+}
+
+// Decodes the transaction and applies it to the given state
+func (dbc *DataBlockChain) apply(state state, encodedTx []byte) error {
+	tx := make([]byte, base64.StdEncoding.DecodedLen(len(encodedTx)))
+	n, err := base64.StdEncoding.Decode(tx, encodedTx)
+	if err != nil {
+		return modules.ErrTxDecode.Wrap("base64: " + err.Error())
+	}
+	tx = tx[:n]
 	var transaction messages.Transaction
-	_ = json.Unmarshal(tx, &transaction)
+	if err := json.Unmarshal(tx, &transaction); err != nil {
+		return modules.ErrTxDecode.Wrap("json: " + err.Error())
+	}
+	if err := transaction.Check(); err != nil {
+		return err
+	}
+	message := transaction.Message()
+	if err := message.Verify(state.Balance.ChainID); err != nil { // signature is verified before charging the fee
+		return err
+	}
 	txHash := sha256.Sum256(tx)
 	fee := &modules.Fee{
+		User:    message.Payer(),
 		ValAddr: dbc.Proposer,
 		TxHash:  txHash[:],
+		Nonce:   message.GetNonce(),
+	}
+	if err := state.Balance.AddFee(fee); err != nil {
+		return err
 	}
-	var feeErr error
-	var txErr error
 	switch transaction.TxType {
 	case messages.TxAddData:
-		description := transaction.Description
-		fee.User = description.Requirer
-		if feeErr = dbc.New.Balance.AddFee(fee); feeErr == nil {
-			txErr = dbc.New.Dataset.AddData(description)
-		}
+		return state.Dataset.AddData(transaction.Description)
 	case messages.TxAddValidation:
-		validation := transaction.Validation
-		fee.User = validation.ValidatorAddr
-		if feeErr := dbc.New.Balance.AddFee(fee); feeErr == nil {
-			txErr = dbc.New.Dataset.AddValidation(validation, transaction.DataIndex)
-		}
+		return state.Dataset.AddValidation(transaction.Validation, transaction.DataIndex)
 	case messages.TxAddPayload:
-		payload := transaction.Payload
-		fee.User = payload.ProviderAddr
-		if feeErr := dbc.New.Balance.AddFee(fee); feeErr == nil {
-			txErr = dbc.New.Dataset.AddPayload(payload, transaction.DataIndex, transaction.VersionIndex)
-		}
+		return state.Dataset.AddPayload(transaction.Payload, transaction.DataIndex, transaction.VersionIndex)
 	case messages.TxAcceptPayload:
-		acceptedPayload := transaction.AcceptedPayload
-		fee.User = acceptedPayload.AcceptorAddr
-		if feeErr := dbc.New.Balance.AddFee(fee); feeErr == nil {
-			txErr = dbc.New.Dataset.AcceptPayload(acceptedPayload, transaction.DataIndex, transaction.VersionIndex)
-		}
+		return state.Dataset.AcceptPayload(transaction.AcceptedPayload, transaction.DataIndex, transaction.VersionIndex)
+	case messages.TxRejectPayload:
+		return state.Dataset.RejectPayload(transaction.RejectedPayload, transaction.DataIndex, transaction.VersionIndex)
+	case messages.TxCloseData:
+		return state.Dataset.CloseData(transaction.CloseData, transaction.DataIndex)
+	case messages.TxOpenDispute:
+		return state.Dataset.OpenDispute(transaction.Dispute, transaction.DataIndex, transaction.VersionIndex)
+	case messages.TxResolveDispute:
+		return state.Dataset.ResolveDispute(transaction.Resolution, transaction.DataIndex, transaction.VersionIndex)
+	case messages.TxRevealPayload:
+		return state.Dataset.RevealPayload(transaction.Reveal, transaction.DataIndex, transaction.VersionIndex)
+	case messages.TxRegisterSchema:
+		return state.Dataset.RegisterSchema(transaction.Schema)
 	case messages.TxTransfer:
-		transfer := transaction.Transfer
-		fee.User = transfer.Sender
-		if feeErr := dbc.New.Balance.AddFee(fee); feeErr == nil {
-			txErr = dbc.New.Balance.AddTransfer(transfer)
-		}
+		return state.Balance.AddTransfer(transaction.Transfer)
 	case messages.TxStake:
-		stake := transaction.Stake
-		fee.User = stake.User
-		if feeErr := dbc.New.Balance.AddFee(fee); feeErr == nil {
-			txErr = dbc.New.Balance.AddStake(stake)
-		}
-	}
-	code := uint32(0)
-	feedback := "transaction delivered successfully"
-	if feeErr != nil {
-		code = 1
-		feedback = feeErr.Error()
-	} else if txErr != nil {
-		code = 1
-		feedback = txErr.Error()
-	}
-	responseDeliverTx := tendermint.ResponseDeliverTx{
-		Code:      code,
-		Data:      nil,
-		Log:       feedback,
-		Info:      feedback,
-		GasWanted: 0,
-		GasUsed:   0,
-		Events:    nil,
-		Codespace: "",
+		return state.Balance.AddStake(transaction.Stake)
 	}
-	return responseDeliverTx
+	return nil
 }
 
 func (dbc *DataBlockChain) EndBlock(requestEndBlock tendermint.RequestEndBlock) tendermint.ResponseEndBlock {
@@ -241,23 +286,24 @@ func (dbc *DataBlockChain) EndBlock(requestEndBlock tendermint.RequestEndBlock)
 	responseEndBlock := tendermint.ResponseEndBlock{
 		ValidatorUpdates:      validatorUpdates,
 		ConsensusParamUpdates: nil,
-		Events:                nil,
+		Events:                dbc.New.Balance.Events(),
 	}
 	return responseEndBlock
 }
 
 func (dbc *DataBlockChain) Commit() tendermint.ResponseCommit {
-	if dbc.Height > 0 { // we don't append to confirmed in the first commit, since there's no committed state yet
-		dbc.Confirmed = append(dbc.Confirmed, dbc.Committed)
+	if err := saveState(dbc.db, dbc.Height+1, dbc.Time, dbc.New); err != nil {
+		panic(err) // the node can't go on without persisting the state it agreed on
 	}
+	dbc.New.index = newIndex(dbc.New.Dataset)
+	dbc.New.leaves = dbc.New.items()
+	dbc.New.appHash = dbc.New.hash()
+	dbc.mutex.Lock()
 	dbc.Committed = dbc.New
-	balance := modules.NewBalance(dbc.Committed.Balance)
-	dataset := modules.NewDataset(dbc.Committed.Dataset, balance)
-	dbc.New = state{
-		Dataset: dataset,
-		Balance: balance,
-	}
+	dbc.mutex.Unlock()
+	dbc.New = dbc.Committed.next()
 	dbc.Height++
+	dbc.resetCheck()
 	responseCommit := tendermint.ResponseCommit{
 		Data:         dbc.Committed.hash(),
 		RetainHeight: 0,
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"bytes"
+	"dbc-node/crypto"
+	"dbc-node/modules"
+	"encoding/hex"
+	"encoding/json"
+	tendermint "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"strconv"
+)
+
+/*
+The app_state of genesis.json sets the initial state of the chain: the accounts and their balances,
+the stakes of the validators, the data requests already open and the parameters of the chain.
+InitChain loads it, checks the stakes against the Tendermint genesis validators and returns them as the validator set.
+Public keys are hex encoded, amounts in sats; the descriptions are signed for the chain ID of the genesis
+and their rewards are escrowed from the balance of their requirer.
+*/
+
+type GenesisState struct {
+	Accounts []GenesisAccount       `json:"accounts"`
+	Stakes   []GenesisStake         `json:"stakes"`
+	Data     []*modules.Description `json:"data"`
+	Params   modules.Params         `json:"params"`
+}
+
+type GenesisAccount struct {
+	PubKey  string `json:"pub_key"` // secp256k1
+	Balance int64  `json:"balance"`
+}
+
+type GenesisStake struct {
+	Validator string `json:"validator"` // ed25519
+	Amount    int64  `json:"amount"`
+}
+
+/*
+A gentx is the signed self-stake of a validator joining a genesis, the stake of its operator account
+signed for the chain ID of the genesis with the GenTx sign doc, which can't be replayed as a TxStake. Peer is the address of its node, id@host:port, for the other nodes.
+*/
+type GenTx struct {
+	Stake *modules.Stake `json:"stake"`
+	Peer  string         `json:"peer,omitempty"`
+}
+
+// Decodes and checks the app_state of a genesis, an empty app_state is an empty chain
+func ParseGenesisState(appState []byte) (*GenesisState, error) {
+	genesis := &GenesisState{}
+	if len(bytes.TrimSpace(appState)) == 0 {
+		return genesis, nil
+	}
+	if err := json.Unmarshal(appState, genesis); err != nil {
+		return nil, modules.ErrInvalidGenesis.Wrap(err.Error())
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+// Checks the keys and amounts of the genesis, the data requests are checked when loaded
+func (genesis *GenesisState) Validate() error {
+	var supply int64
+	accounts := make(map[string]bool)
+	for _, account := range genesis.Accounts {
+		pubKey, err := hex.DecodeString(account.PubKey)
+		if err != nil || crypto.CheckPubKey(pubKey) != nil {
+			return modules.ErrInvalidGenesis.Wrap("account " + account.PubKey)
+		}
+		if accounts[hex.EncodeToString(pubKey)] {
+			return modules.ErrInvalidGenesis.Wrap("duplicate account " + account.PubKey)
+		}
+		accounts[hex.EncodeToString(pubKey)] = true
+		if supply, err = addSupply(supply, account.Balance); err != nil {
+			return err
+		}
+	}
+	stakes := make(map[string]bool)
+	for _, stake := range genesis.Stakes {
+		validator, err := hex.DecodeString(stake.Validator)
+		if err != nil || len(validator) != ed25519.PubKeyEd25519Size {
+			return modules.ErrInvalidGenesis.Wrap("validator " + stake.Validator)
+		}
+		if stakes[hex.EncodeToString(validator)] {
+			return modules.ErrInvalidGenesis.Wrap("duplicate validator " + stake.Validator)
+		}
+		stakes[hex.EncodeToString(validator)] = true
+		if stake.Amount <= 0 {
+			return modules.ErrInvalidGenesis.Wrap("stake of " + stake.Validator)
+		}
+		if supply, err = addSupply(supply, stake.Amount); err != nil {
+			return err
+		}
+	}
+	if err := genesis.Params.Check(); err != nil {
+		return modules.ErrInvalidGenesis.Wrap(err.Error())
+	}
+	return nil
+}
+
+func addSupply(supply, amount int64) (int64, error) {
+	if amount < 0 {
+		return 0, modules.ErrInvalidGenesis.Wrap("negative amount")
+	}
+	if amount > modules.SatsSupply-supply {
+		return 0, modules.ErrInvalidGenesis.Wrap("more than " + strconv.FormatInt(modules.SatsSupply, 10) + " sats")
+	}
+	return supply + amount, nil
+}
+
+// Returns the validator set of the genesis, the voting power of a validator is its stake
+func (genesis *GenesisState) Validators() []tendermint.ValidatorUpdate {
+	var validators []tendermint.ValidatorUpdate
+	for _, stake := range genesis.Stakes {
+		validator, _ := hex.DecodeString(stake.Validator)
+		validators = append(validators, tendermint.Ed25519ValidatorUpdate(validator, stake.Amount))
+	}
+	return validators
+}
+
+// Checks the Tendermint genesis validators, if any, are the validators of the genesis with their stake as power
+func (genesis *GenesisState) checkValidators(validators []tendermint.ValidatorUpdate) error {
+	if len(validators) == 0 {
+		return nil // the validator set is the one returned by InitChain
+	}
+	stakes := make(map[string]int64)
+	for _, stake := range genesis.Stakes {
+		validator, _ := hex.DecodeString(stake.Validator)
+		stakes[hex.EncodeToString(validator)] = stake.Amount
+	}
+	if len(validators) != len(stakes) {
+		return modules.ErrInvalidGenesis.Wrap("validators don't match the stakes")
+	}
+	for _, validator := range validators {
+		key := hex.EncodeToString(validator.PubKey.Data)
+		if validator.PubKey.Type != tendermint.PubKeyEd25519 {
+			return modules.ErrInvalidGenesis.Wrap("validator " + key + " isn't ed25519")
+		}
+		stake, ok := stakes[key]
+		if !ok {
+			return modules.ErrInvalidGenesis.Wrap("validator " + key + " has no stake")
+		}
+		if stake != validator.Power {
+			return modules.ErrInvalidGenesis.Wrap("power of validator " + key + " isn't its stake")
+		}
+	}
+	return nil
+}
+
+// Checks the genesis can start a chain with the chain ID and the Tendermint genesis validators
+func (genesis *GenesisState) Check(chainID string, validators []tendermint.ValidatorUpdate) error {
+	if err := genesis.Validate(); err != nil {
+		return err
+	}
+	if err := genesis.checkValidators(validators); err != nil {
+		return err
+	}
+	_, err := genesis.load(chainID)
+	return err
+}
+
+// Moves the amount of the gentx stake from the account of its user to the stake of its validator
+func (genesis *GenesisState) AddGenTx(chainID string, genTx *GenTx) error {
+	stake := genTx.Stake
+	if stake == nil {
+		return modules.ErrInvalidGenesis.Wrap("gentx without stake")
+	}
+	if err := stake.VerifyGenTx(chainID); err != nil {
+		return modules.ErrInvalidGenesis.Wrap("gentx: " + err.Error())
+	}
+	if stake.Amount <= 0 {
+		return modules.ErrInvalidGenesis.Wrap("gentx stake of " + hex.EncodeToString(stake.Validator))
+	}
+	var account *GenesisAccount
+	for i := range genesis.Accounts {
+		if pubKey, _ := hex.DecodeString(genesis.Accounts[i].PubKey); bytes.Compare(pubKey, stake.User) == 0 {
+			account = &genesis.Accounts[i]
+		}
+	}
+	if account == nil || account.Balance < stake.Amount {
+		return modules.ErrInvalidGenesis.Wrap("gentx account " + hex.EncodeToString(stake.User) + " can't pay its stake")
+	}
+	account.Balance -= stake.Amount
+	for i := range genesis.Stakes {
+		if validator, _ := hex.DecodeString(genesis.Stakes[i].Validator); bytes.Compare(validator, stake.Validator) == 0 {
+			genesis.Stakes[i].Amount += stake.Amount
+			return nil
+		}
+	}
+	genesis.Stakes = append(genesis.Stakes, GenesisStake{Validator: hex.EncodeToString(stake.Validator), Amount: stake.Amount})
+	return nil
+}
+
+// Returns the initial state of the chain
+func (genesis *GenesisState) load(chainID string) (state, error) {
+	users := make(map[string]int64)
+	for _, account := range genesis.Accounts {
+		pubKey, _ := hex.DecodeString(account.PubKey)
+		users[hex.EncodeToString(pubKey)] = account.Balance
+	}
+	validators := make(map[string]int64)
+	for _, stake := range genesis.Stakes {
+		validator, _ := hex.DecodeString(stake.Validator)
+		validators[hex.EncodeToString(validator)] = stake.Amount
+	}
+	balance := modules.NewBalance(&modules.Balance{
+		ChainID:    chainID,
+		Params:     genesis.Params,
+		Users:      users,
+		Validators: validators,
+	})
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	for i, description := range genesis.Data {
+		if err := dataset.AddData(description); err != nil {
+			return state{}, modules.ErrInvalidGenesis.Wrap("data " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	balance.Events() // nobody to report the genesis events to
+	return state{
+		Dataset: dataset,
+		Balance: balance,
+	}, nil
+}
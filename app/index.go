@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bytes"
+	"dbc-node/messages"
+	"dbc-node/modules"
+	"encoding/hex"
+)
+
+/*
+The index lists the data and versions of the dataset by the public keys taking part in them, for each role,
+and by what they are waiting for. It isn't part of the state: it's built from the committed dataset at every commit,
+and on the fly for queries at older heights. Entries are in dataset order, data before its versions.
+*/
+
+type index struct {
+	roles  map[string]map[string][]messages.IndexEntry // role, hex public key
+	states map[string][]messages.IndexEntry
+}
+
+func newIndex(dataset *modules.Dataset) *index {
+	index := &index{
+		roles:  make(map[string]map[string][]messages.IndexEntry),
+		states: make(map[string][]messages.IndexEntry),
+	}
+	for i := range dataset.DataList {
+		data := &dataset.DataList[i]
+		entry := messages.IndexEntry{Data: i, Version: -1}
+		index.add(messages.RoleRequirer, data.Description.Requirer, entry)
+		for _, validator := range data.Description.Validators {
+			index.add(messages.RoleValidator, validator, entry)
+		}
+		for _, acceptor := range data.Description.Acceptors {
+			index.add(messages.RoleAcceptor, acceptor, entry)
+		}
+		if data.Closed {
+			index.states[messages.StateClosed] = append(index.states[messages.StateClosed], entry)
+		} else if data.AwaitingValidation() {
+			index.states[messages.StateAwaitingValidation] = append(index.states[messages.StateAwaitingValidation], entry)
+		}
+		for j := range data.VersionList {
+			version := &data.VersionList[j]
+			entry := messages.IndexEntry{Data: i, Version: j}
+			index.add(messages.RoleValidator, version.Validation.ValidatorAddr, entry)
+			if !version.Payload.IsEmpty() {
+				index.add(messages.RoleProvider, version.Payload.ProviderAddr, entry)
+			}
+			for _, acceptance := range version.Acceptances {
+				index.add(messages.RoleAcceptor, acceptance.AcceptorAddr, entry)
+			}
+			if version.RejectedPayload != nil && !version.RejectedPayload.IsEmpty() {
+				index.add(messages.RoleAcceptor, version.RejectedPayload.AcceptorAddr, entry)
+			}
+			if data.Closed {
+				continue
+			}
+			if version.AwaitingPayload() {
+				index.states[messages.StateAwaitingPayload] = append(index.states[messages.StateAwaitingPayload], entry)
+			} else if version.AwaitingAcceptance() {
+				index.states[messages.StateAwaitingAcceptance] = append(index.states[messages.StateAwaitingAcceptance], entry)
+			}
+		}
+	}
+	return index
+}
+
+// Adds the entry to the key, once: a key can be listed twice in a description
+func (index *index) add(role string, pubKey []byte, entry messages.IndexEntry) {
+	key := hex.EncodeToString(pubKey)
+	if index.roles[role] == nil {
+		index.roles[role] = make(map[string][]messages.IndexEntry)
+	}
+	entries := index.roles[role][key]
+	if len(entries) > 0 && entries[len(entries)-1] == entry {
+		return
+	}
+	index.roles[role][key] = append(entries, entry)
+}
+
+/*
+Returns the entries in the state the key takes part in with the role: requirers and acceptors take part in every
+version of their data, providers and validators in their own versions only, and in the data of these versions.
+Versions already accepted by an acceptor aren't waiting for its acceptance anymore.
+*/
+func (index *index) intersect(dataset *modules.Dataset, role, pubKey, state string) []messages.IndexEntry {
+	byVersion := (role == messages.RoleProvider || role == messages.RoleValidator) &&
+		(state == messages.StateAwaitingPayload || state == messages.StateAwaitingAcceptance)
+	taking := make(map[messages.IndexEntry]bool)
+	for _, entry := range index.roles[role][pubKey] {
+		if !byVersion {
+			entry.Version = -1
+		}
+		taking[entry] = true
+	}
+	key, _ := hex.DecodeString(pubKey)
+	var entries []messages.IndexEntry
+	for _, entry := range index.states[state] {
+		match := entry
+		if !byVersion {
+			match.Version = -1
+		}
+		if !taking[match] {
+			continue
+		}
+		if role == messages.RoleAcceptor && state == messages.StateAwaitingAcceptance &&
+			hasAccepted(&dataset.DataList[entry.Data].VersionList[entry.Version], key) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func hasAccepted(version *modules.Version, acceptor []byte) bool {
+	for _, acceptance := range version.Acceptances {
+		if bytes.Compare(acceptance.AcceptorAddr, acceptor) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the index of the state, built if the state is not the committed one
+func (state state) indexes() *index {
+	if state.index != nil {
+		return state.index
+	}
+	return newIndex(state.Dataset)
+}
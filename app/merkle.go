@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	"strconv"
+)
+
+/*
+The app hash is the root of a simple merkle map over every item of the state: data, versions, schemas,
+accounts, stakes and rewards, each one encoded in JSON. A query for a single item can return a proof of its value
+against the app hash, verifiable with the key path "/" + url escaped key.
+*/
+
+func dataKey(dataIndex int) string {
+	return "data/" + strconv.Itoa(dataIndex)
+}
+
+func versionKey(dataIndex, versionIndex int) string {
+	return dataKey(dataIndex) + "/versions/" + strconv.Itoa(versionIndex)
+}
+
+func accountKey(user string) string {
+	return "account/" + user
+}
+
+func stakeKey(validator string) string {
+	return "stake/" + validator
+}
+
+func rewardKey(rewardIndex int) string {
+	return "reward/" + strconv.Itoa(rewardIndex)
+}
+
+func schemaKey(id string) string {
+	return "schema/" + id
+}
+
+func (state state) items() map[string][]byte {
+	items := make(map[string][]byte)
+	if state.Dataset != nil {
+		for i, data := range state.Dataset.DataList {
+			items[dataKey(i)], _ = json.Marshal(data)
+			for j, version := range data.VersionList {
+				items[versionKey(i, j)], _ = json.Marshal(version)
+			}
+		}
+		for id, schema := range state.Dataset.Schemas {
+			items[schemaKey(id)], _ = json.Marshal(schema)
+		}
+	}
+	if state.Balance != nil {
+		for user := range state.Balance.Users {
+			items[accountKey(user)], _ = json.Marshal(state.Balance.Account(user))
+		}
+		for user := range state.Balance.Nonces {
+			items[accountKey(user)], _ = json.Marshal(state.Balance.Account(user))
+		}
+		for validator, stake := range state.Balance.Validators {
+			items[stakeKey(validator)], _ = json.Marshal(stake)
+		}
+		for i, reward := range state.Balance.Rewards {
+			items[rewardKey(i)], _ = json.Marshal(reward)
+		}
+	}
+	return items
+}
+
+// Returns the app hash, computed once at commit for the committed state
+func (state state) hash() []byte {
+	if state.appHash != nil {
+		return state.appHash
+	}
+	leaves := state.leaves
+	if leaves == nil {
+		leaves = state.items()
+	}
+	return merkle.SimpleHashFromMap(leaves)
+}
+
+// Returns the proof of the value of the item at key against the app hash, nil if the item doesn't exist
+func (state state) prove(key string) *merkle.Proof {
+	leaves := state.leaves
+	if leaves == nil { // states loaded for past heights aren't cached
+		leaves = state.items()
+	}
+	_, proofs, _ := merkle.SimpleProofsFromMap(leaves)
+	proof, ok := proofs[key]
+	if !ok {
+		return nil
+	}
+	op := merkle.NewSimpleValueOp([]byte(key), proof)
+	return &merkle.Proof{Ops: []merkle.ProofOp{op.ProofOp()}}
+}
@@ -0,0 +1,446 @@
+package app
+
+import (
+	"dbc-node/messages"
+	"dbc-node/modules"
+	"encoding/hex"
+	"encoding/json"
+	tendermint "github.com/tendermint/tendermint/abci/types"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Queries are routed by RequestQuery.Path, every path in messages has a handler here.
+A handler returns the value it reads, along with its key when the value is a single state item, encoded as in
+the merkle map, so it can be proven against the app hash.
+*/
+
+type queryParams map[string]string
+
+type queryHandler func(state state, params queryParams) (key string, value []byte, err error)
+
+var queryRoutes = []struct {
+	path    string
+	handler queryHandler
+}{
+	{messages.PathDataset, queryDataset},
+	{messages.PathData, queryData},
+	{messages.PathDescription, queryDescription},
+	{messages.PathVersion, queryVersion},
+	{messages.PathValidation, queryValidation},
+	{messages.PathPayload, queryPayload},
+	{messages.PathAcceptedPayload, queryAcceptedPayload},
+	{messages.PathRejectedPayload, queryRejectedPayload},
+	{messages.PathReveal, queryReveal},
+	{messages.PathBalances, queryBalances},
+	{messages.PathBalance, queryBalance},
+	{messages.PathStakes, queryStakes},
+	{messages.PathStake, queryStake},
+	{messages.PathSchemas, querySchemas},
+	{messages.PathSchema, querySchema},
+	{messages.PathRoleIndex, queryRoleIndex},
+	{messages.PathStateIndex, queryStateIndex},
+	{messages.PathRoleStateIndex, queryRoleStateIndex},
+}
+
+func (dbc *DataBlockChain) Query(requestQuery tendermint.RequestQuery) tendermint.ResponseQuery {
+	state, height, err := dbc.stateAtHeight(requestQuery.Height)
+	responseQuery := tendermint.ResponseQuery{
+		Code:      uint32(0),
+		Log:       "",
+		Info:      "",
+		Index:     -1,
+		Key:       []byte(requestQuery.Path),
+		Value:     nil,
+		Proof:     nil,
+		Height:    height,
+		Codespace: "",
+	}
+	var key string
+	var value []byte
+	if err == nil {
+		key, value, err = query(state, height, requestQuery.Path)
+	}
+	if err != nil {
+		responseQuery.Code = modules.ErrorCode(err)
+		responseQuery.Codespace = modules.Codespace
+		responseQuery.Log = err.Error()
+		return responseQuery
+	}
+	if key != "" {
+		responseQuery.Key = []byte(key)
+		if requestQuery.Prove {
+			responseQuery.Proof = state.prove(key)
+		}
+	}
+	responseQuery.Value = value
+	return responseQuery
+}
+
+func query(state state, height int64, path string) (string, []byte, error) {
+	handler, params := route(path)
+	if handler == nil {
+		return "", nil, modules.ErrUnknownPath.Wrap(path)
+	}
+	if state.Dataset == nil || state.Balance == nil {
+		return "", nil, modules.ErrNotFound.Wrap("state at height " + strconv.FormatInt(height, 10))
+	}
+	return handler(state, params)
+}
+
+// Matches the path against every route, path segments in braces are returned as params,
+// together with the parameters after "?", like pagination
+func route(path string) (queryHandler, queryParams) {
+	path, rawQuery := split(path)
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, nil
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, queryRoute := range queryRoutes {
+		pattern := strings.Split(strings.Trim(queryRoute.path, "/"), "/")
+		if len(pattern) != len(segments) {
+			continue
+		}
+		params := make(queryParams)
+		for name := range values {
+			params[name] = values.Get(name)
+		}
+		matched := true
+		for i := range pattern {
+			if strings.HasPrefix(pattern[i], "{") && strings.HasSuffix(pattern[i], "}") {
+				params[strings.Trim(pattern[i], "{}")] = segments[i]
+			} else if pattern[i] != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return queryRoute.handler, params
+		}
+	}
+	return nil, nil
+}
+
+func split(path string) (string, string) {
+	if i := strings.Index(path, "?"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+func (params queryParams) index(name string, length int) (int, error) {
+	index, err := strconv.Atoi(params[name])
+	if err != nil || index < 0 {
+		return 0, modules.ErrInvalidArgument.Wrap(name + " " + params[name])
+	}
+	if index >= length {
+		return 0, modules.ErrNotFound.Wrap(name + " " + params[name])
+	}
+	return index, nil
+}
+
+func (params queryParams) pubKey(name string) (string, error) {
+	pubKey, err := hex.DecodeString(params[name])
+	if err != nil || len(pubKey) == 0 {
+		return "", modules.ErrInvalidArgument.Wrap(name + " " + params[name])
+	}
+	return hex.EncodeToString(pubKey), nil // lower case, as in state keys
+}
+
+// Returns the requested page of a list of length items, without its items, and the bounds of its items in the list
+func (params queryParams) page(length int) (messages.Page, int, int, error) {
+	offset, limit := 0, messages.DefaultLimit
+	var err error
+	if value, ok := params["offset"]; ok {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return messages.Page{}, 0, 0, modules.ErrInvalidArgument.Wrap("offset " + value)
+		}
+	}
+	if value, ok := params["limit"]; ok {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > messages.MaxLimit {
+			return messages.Page{}, 0, 0, modules.ErrInvalidArgument.Wrap("limit " + value)
+		}
+	}
+	start := offset
+	if start > length {
+		start = length
+	}
+	end := length
+	if limit < length-start { // offset+limit can overflow
+		end = start + limit
+	}
+	return messages.Page{Total: length, Offset: offset, Limit: limit}, start, end, nil
+}
+
+// Map keys in order, lists of map entries are paginated in this order
+func sortedKeys(maps ...map[string]int64) []string {
+	set := make(map[string]bool)
+	for _, m := range maps {
+		for key := range m {
+			set[key] = true
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func marshal(value interface{}) ([]byte, error) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, modules.ErrInternal.Wrap(err.Error())
+	}
+	return bytes, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// DATASET
+
+func queryDataset(state state, params queryParams) (string, []byte, error) {
+	page, start, end, err := params.page(len(state.Dataset.DataList))
+	if err != nil {
+		return "", nil, err
+	}
+	page.Items = append([]modules.Data{}, state.Dataset.DataList[start:end]...)
+	value, err := marshal(page)
+	return "", value, err
+}
+
+func queryData(state state, params queryParams) (string, []byte, error) {
+	dataIndex, err := params.index("index", len(state.Dataset.DataList))
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex])
+	return dataKey(dataIndex), value, err
+}
+
+func queryDescription(state state, params queryParams) (string, []byte, error) {
+	dataIndex, err := params.index("index", len(state.Dataset.DataList))
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].Description)
+	return "", value, err
+}
+
+func queryVersion(state state, params queryParams) (string, []byte, error) {
+	dataIndex, versionIndex, err := versionParams(state, params)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].VersionList[versionIndex])
+	return versionKey(dataIndex, versionIndex), value, err
+}
+
+func queryValidation(state state, params queryParams) (string, []byte, error) {
+	dataIndex, versionIndex, err := versionParams(state, params)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].VersionList[versionIndex].Validation)
+	return "", value, err
+}
+
+func queryPayload(state state, params queryParams) (string, []byte, error) {
+	dataIndex, versionIndex, err := versionParams(state, params)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].VersionList[versionIndex].Payload)
+	return "", value, err
+}
+
+func queryAcceptedPayload(state state, params queryParams) (string, []byte, error) {
+	dataIndex, versionIndex, err := versionParams(state, params)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].VersionList[versionIndex].AcceptedPayload)
+	return "", value, err
+}
+
+func queryRejectedPayload(state state, params queryParams) (string, []byte, error) {
+	dataIndex, versionIndex, err := versionParams(state, params)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].VersionList[versionIndex].RejectedPayload)
+	return "", value, err
+}
+
+func queryReveal(state state, params queryParams) (string, []byte, error) {
+	dataIndex, versionIndex, err := versionParams(state, params)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := marshal(state.Dataset.DataList[dataIndex].VersionList[versionIndex].Reveal)
+	return "", value, err
+}
+
+func versionParams(state state, params queryParams) (int, int, error) {
+	dataIndex, err := params.index("index", len(state.Dataset.DataList))
+	if err != nil {
+		return 0, 0, err
+	}
+	versionIndex, err := params.index("version", len(state.Dataset.DataList[dataIndex].VersionList))
+	if err != nil {
+		return 0, 0, err
+	}
+	return dataIndex, versionIndex, nil
+}
+
+func querySchemas(state state, params queryParams) (string, []byte, error) {
+	ids := make([]string, 0, len(state.Dataset.Schemas))
+	for id := range state.Dataset.Schemas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	page, start, end, err := params.page(len(ids))
+	if err != nil {
+		return "", nil, err
+	}
+	schemas := []*modules.Schema{}
+	for _, id := range ids[start:end] {
+		schemas = append(schemas, state.Dataset.Schemas[id])
+	}
+	page.Items = schemas
+	value, err := marshal(page)
+	return "", value, err
+}
+
+func querySchema(state state, params queryParams) (string, []byte, error) {
+	schema, ok := state.Dataset.Schemas[params["id"]]
+	if !ok {
+		return "", nil, modules.ErrNotFound.Wrap("schema " + params["id"])
+	}
+	value, err := marshal(schema)
+	return schemaKey(params["id"]), value, err
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// BALANCE
+
+func queryBalances(state state, params queryParams) (string, []byte, error) {
+	users := sortedKeys(state.Balance.Users, state.Balance.Nonces)
+	page, start, end, err := params.page(len(users))
+	if err != nil {
+		return "", nil, err
+	}
+	accounts := []messages.AccountEntry{}
+	for _, user := range users[start:end] {
+		accounts = append(accounts, messages.AccountEntry{User: user, Account: state.Balance.Account(user)})
+	}
+	page.Items = accounts
+	value, err := marshal(page)
+	return "", value, err
+}
+
+func queryBalance(state state, params queryParams) (string, []byte, error) {
+	user, err := params.pubKey("pubkey")
+	if err != nil {
+		return "", nil, err
+	}
+	_, hasBalance := state.Balance.Users[user]
+	_, hasNonce := state.Balance.Nonces[user]
+	if !hasBalance && !hasNonce {
+		return "", nil, modules.ErrNotFound.Wrap("account " + user)
+	}
+	value, err := marshal(state.Balance.Account(user))
+	return accountKey(user), value, err
+}
+
+func queryStakes(state state, params queryParams) (string, []byte, error) {
+	validators := sortedKeys(state.Balance.Validators)
+	page, start, end, err := params.page(len(validators))
+	if err != nil {
+		return "", nil, err
+	}
+	stakes := []messages.StakeEntry{}
+	for _, validator := range validators[start:end] {
+		stakes = append(stakes, messages.StakeEntry{Validator: validator, Stake: state.Balance.Validators[validator]})
+	}
+	page.Items = stakes
+	value, err := marshal(page)
+	return "", value, err
+}
+
+func queryStake(state state, params queryParams) (string, []byte, error) {
+	validator, err := params.pubKey("validator")
+	if err != nil {
+		return "", nil, err
+	}
+	stake, ok := state.Balance.Validators[validator]
+	if !ok {
+		return "", nil, modules.ErrNotFound.Wrap("validator " + validator)
+	}
+	value, err := marshal(stake)
+	return stakeKey(validator), value, err
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// INDEX
+
+func queryRoleIndex(state state, params queryParams) (string, []byte, error) {
+	role, pubKey, err := roleParams(params)
+	if err != nil {
+		return "", nil, err
+	}
+	return indexPage(state.indexes().roles[role][pubKey], params)
+}
+
+func queryStateIndex(state state, params queryParams) (string, []byte, error) {
+	indexState, err := stateParam(params)
+	if err != nil {
+		return "", nil, err
+	}
+	return indexPage(state.indexes().states[indexState], params)
+}
+
+func queryRoleStateIndex(state state, params queryParams) (string, []byte, error) {
+	role, pubKey, err := roleParams(params)
+	if err != nil {
+		return "", nil, err
+	}
+	indexState, err := stateParam(params)
+	if err != nil {
+		return "", nil, err
+	}
+	return indexPage(state.indexes().intersect(state.Dataset, role, pubKey, indexState), params)
+}
+
+func roleParams(params queryParams) (string, string, error) {
+	switch role := params["role"]; role {
+	case messages.RoleRequirer, messages.RoleValidator, messages.RoleProvider, messages.RoleAcceptor:
+		pubKey, err := params.pubKey("pubkey")
+		return role, pubKey, err
+	default:
+		return "", "", modules.ErrInvalidArgument.Wrap("role " + role)
+	}
+}
+
+func stateParam(params queryParams) (string, error) {
+	switch indexState := params["state"]; indexState {
+	case messages.StateAwaitingValidation, messages.StateAwaitingPayload, messages.StateAwaitingAcceptance, messages.StateClosed:
+		return indexState, nil
+	default:
+		return "", modules.ErrInvalidArgument.Wrap("state " + indexState)
+	}
+}
+
+func indexPage(entries []messages.IndexEntry, params queryParams) (string, []byte, error) {
+	page, start, end, err := params.page(len(entries))
+	if err != nil {
+		return "", nil, err
+	}
+	page.Items = append([]messages.IndexEntry{}, entries[start:end]...)
+	value, err := marshal(page)
+	return "", value, err
+}
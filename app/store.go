@@ -0,0 +1,91 @@
+package app
+
+import (
+	"dbc-node/modules"
+	"encoding/binary"
+	"encoding/json"
+	dbm "github.com/tendermint/tm-db"
+	"strconv"
+)
+
+/*
+The store keeps a snapshot of the committed state for the last KeepHeights heights, the last committed height and
+the time of its block. They are written in a single batch at commit, together with the pruning of the oldest snapshot,
+so a restarted node resumes from the last height and Tendermint only replays the blocks the app hasn't seen yet.
+Snapshots hold the state only, the history of transfers, stakes and fees isn't persisted.
+*/
+
+// Committed heights whose state can be queried, the snapshots of older heights are deleted
+const KeepHeights = 100
+
+var (
+	heightKey = []byte("height")
+	timeKey   = []byte("time")
+)
+
+func stateKey(height int64) []byte {
+	return []byte("state/" + strconv.FormatInt(height, 10))
+}
+
+func saveState(db dbm.DB, height, time int64, state state) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch()
+	defer batch.Close()
+	batch.Set(stateKey(height), value)
+	batch.Set(heightKey, encodeInt(height))
+	batch.Set(timeKey, encodeInt(time))
+	if height > KeepHeights {
+		batch.Delete(stateKey(height - KeepHeights))
+	}
+	return batch.WriteSync()
+}
+
+// Returns the last committed height and the time of its block, zero if nothing was committed
+func loadHeight(db dbm.DB) (int64, int64, error) {
+	height, err := loadInt(db, heightKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	time, err := loadInt(db, timeKey)
+	return height, time, err
+}
+
+func encodeInt(value int64) []byte {
+	bytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(bytes, uint64(value))
+	return bytes
+}
+
+func loadInt(db dbm.DB, key []byte) (int64, error) {
+	bytes, err := db.Get(key)
+	if err != nil || bytes == nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(bytes)), nil
+}
+
+func loadState(db dbm.DB, height int64) (state, error) {
+	value, err := db.Get(stateKey(height))
+	if err != nil {
+		return state{}, err
+	}
+	if value == nil {
+		return state{}, modules.ErrNotFound.Wrap("state at height " + strconv.FormatInt(height, 10))
+	}
+	stored := state{
+		Dataset: &modules.Dataset{},
+		Balance: &modules.Balance{},
+	}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return state{}, err
+	}
+	balance := modules.NewBalance(stored.Balance)
+	dataset := modules.NewDataset(stored.Dataset, balance)
+	return state{
+		Dataset: dataset,
+		Balance: balance,
+	}, nil
+}
@@ -0,0 +1,128 @@
+package blobs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+The blob store keeps the encrypted contents referenced on chain by payloads and accepted payloads (ContentHash and ContentSize),
+so that they stay out of the blocks. Each content is a file named after the hex encoding of its sha256 hash.
+A content is accepted only if it's committed on chain, with the same hash and size, at most modules.MaxContentSize,
+so an upload never reads more than that.
+Served over HTTP: PUT /blobs/{hash} uploads a content, GET /blobs/{hash} downloads it.
+*/
+
+const Route = "/blobs/"
+
+var (
+	ErrNotCommitted = errors.New("content not committed on chain")
+	ErrMismatch     = errors.New("content doesn't match the committed hash and size")
+	ErrNotFound     = errors.New("content not found")
+)
+
+// Committed returns the size of the content committed on chain with the given hash
+type Committed func(hash []byte) (size int64, ok bool)
+
+type Store struct {
+	dir       string
+	committed Committed
+}
+
+func NewStore(dir string, committed Committed) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, committed: committed}, nil
+}
+
+func (store *Store) Put(hash, content []byte) error {
+	size, ok := store.committed(hash)
+	if !ok {
+		return ErrNotCommitted
+	}
+	contentHash := sha256.Sum256(content)
+	if int64(len(content)) != size || bytes.Compare(contentHash[:], hash) != 0 {
+		return ErrMismatch
+	}
+	temp, err := ioutil.TempFile(store.dir, hex.EncodeToString(hash)+".*.tmp") // one for each concurrent upload
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // fails once renamed
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), store.path(hash)) // never serve a partial content
+}
+
+func (store *Store) Get(hash []byte) ([]byte, error) {
+	content, err := ioutil.ReadFile(store.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return content, err
+}
+
+func (store *Store) path(hash []byte) string {
+	return filepath.Join(store.dir, hex.EncodeToString(hash))
+}
+
+func (store *Store) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(request.URL.Path, Route))
+	if err != nil || len(hash) != sha256.Size || !strings.HasPrefix(request.URL.Path, Route) {
+		http.Error(writer, "invalid content hash", http.StatusBadRequest)
+		return
+	}
+	switch request.Method {
+	case http.MethodGet:
+		content, err := store.Get(hash)
+		if err != nil {
+			http.Error(writer, err.Error(), status(err))
+			return
+		}
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Write(content)
+	case http.MethodPut:
+		size, ok := store.committed(hash)
+		if !ok {
+			http.Error(writer, ErrNotCommitted.Error(), status(ErrNotCommitted))
+			return
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(request.Body, size+1)) // a longer content doesn't match anyway
+		if err == nil {
+			err = store.Put(hash, content)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), status(err))
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func status(err error) int {
+	switch err {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrNotCommitted:
+		return http.StatusForbidden
+	case ErrMismatch:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
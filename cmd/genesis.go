@@ -1,24 +1,297 @@
 package cmd
 
 import (
+	"dbc-node/app"
+	"dbc-node/crypto"
 	"dbc-node/modules"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	tendermint "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/config"
 	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/p2p"
+	"github.com/tendermint/tendermint/privval"
+	"github.com/tendermint/tendermint/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var genUsers = map[string]int64{
-	"0476bf074f9f881b24619c3ffbb33683069626f117f3a3fd2f1ddda13b3485b45cd2e084356d0571fa370b33ec770039c6a6b371ae4b37ac99a8d708ed3b38d3fc": modules.ToSats(50),
+/*
+The genesis commands build the genesis.json of a network with several validators:
+the coordinator runs init with the chain ID and adds the accounts, every operator runs add-validator
+on its own node with that genesis, the coordinator collects their gentxs and shares the resulting genesis.json.
+*/
+
+// Funded account of the genesis written by init, change the app_state of genesis.json to distribute the supply
+const defaultAccount = "0476bf074f9f881b24619c3ffbb33683069626f117f3a3fd2f1ddda13b3485b45cd2e084356d0571fa370b33ec770039c6a6b371ae4b37ac99a8d708ed3b38d3fc"
+
+var (
+	defaultBalance = modules.ToSats(50)
+	defaultStake   = modules.ToSats(10)
+)
+
+var (
+	genTxFrom    string
+	genTxKey     string
+	genTxPubKey  string
+	genTxAddress string
+	genTxDir     string
+)
+
+var GenesisCmd = &cobra.Command{
+	Use:   "genesis",
+	Short: "Build the genesis file of a network",
+}
+
+var addAccountCmd = &cobra.Command{
+	Use:          "add-account <pubkey> <sats>",
+	Short:        "Add a funded account to the genesis",
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE:         addAccount,
 }
-var genValidators map[string]int64 = map[string]int64{
-	"c468322724705d01fe22c6727890a9a9293d006bc873e73342d85fb36716642c": modules.ToSats(10),
+
+var addValidatorCmd = &cobra.Command{
+	Use:          "add-validator <sats>",
+	Short:        "Write a gentx staking from an account of the genesis to the validator of the node",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         addValidator,
+}
+
+var collectCmd = &cobra.Command{
+	Use:          "collect",
+	Short:        "Add the stakes of the gentxs to the genesis and make their validators the genesis validators",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         collect,
+}
+
+var validateCmd = &cobra.Command{
+	Use:          "validate [genesis.json]",
+	Short:        "Check a genesis file can start the chain",
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE:         validate,
 }
-var genValidatorKeys []ed25519.PubKeyEd25519
 
 func init() {
-	for key := range genValidators {
-		var validatorKey ed25519.PubKeyEd25519
-		bytes, _ := hex.DecodeString(key)
-		copy(validatorKey[:], bytes)
-		genValidatorKeys = append(genValidatorKeys, validatorKey)
+	GenesisCmd.AddCommand(addAccountCmd, addValidatorCmd, collectCmd, validateCmd)
+	addValidatorCmd.Flags().StringVar(&genTxFrom, "from", "", "Name of the account key in the keystore")
+	addValidatorCmd.Flags().StringVar(&keystoreDir, "keystore", "", "Directory of the keystore (default <home>/keystore)")
+	addValidatorCmd.Flags().StringVar(&genTxKey, "key", "", "PEM file of the secp256k1 private key of the account")
+	addValidatorCmd.Flags().StringVar(&genTxPubKey, "pubkey", "", "PEM file of the secp256k1 public key of the account")
+	addValidatorCmd.Flags().StringVar(&genTxAddress, "address", "", "host:port the other nodes reach the node at")
+	collectCmd.Flags().StringVar(&genTxDir, "gentx-dir", "", "Directory of the gentxs (default <home>/config/gentx)")
+}
+
+// Returns the app_state of a new chain, with the node as the only validator
+func defaultGenesisState(validator ed25519.PubKeyEd25519) *app.GenesisState {
+	return &app.GenesisState{
+		Accounts: []app.GenesisAccount{{PubKey: defaultAccount, Balance: defaultBalance}},
+		Stakes:   []app.GenesisStake{{Validator: hex.EncodeToString(validator[:]), Amount: defaultStake}},
+	}
+}
+
+func addAccount(cmd *cobra.Command, args []string) error {
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errors.New("invalid amount " + args[1])
+	}
+	genFile := loadConfig().GenesisFile()
+	genDoc, genesis, err := readGenesis(genFile)
+	if err != nil {
+		return err
+	}
+	genesis.Accounts = append(genesis.Accounts, app.GenesisAccount{PubKey: strings.ToLower(args[0]), Balance: amount})
+	if err := genesis.Validate(); err != nil {
+		return err
+	}
+	return writeGenesis(genFile, genDoc, genesis)
+}
+
+func addValidator(cmd *cobra.Command, args []string) error {
+	amount, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || amount <= 0 {
+		return errors.New("invalid amount " + args[0])
+	}
+	privKey, pubKey, err := accountKeys()
+	if err != nil {
+		return err
+	}
+	configuration := loadConfig()
+	genDoc, _, err := readGenesis(configuration.GenesisFile())
+	if err != nil {
+		return err
+	}
+	privVal := privval.LoadFilePV(configuration.PrivValidatorKeyFile(), configuration.PrivValidatorStateFile())
+	validator := privVal.Key.PubKey.(ed25519.PubKeyEd25519)
+	nodeKey, err := p2p.LoadNodeKey(configuration.NodeKeyFile())
+	if err != nil {
+		return err
+	}
+
+	stake := modules.Stake{
+		User:      pubKey,
+		Validator: validator[:],
+		Amount:    amount,
+		Time:      time.Now().Unix(),
+	}
+	stake.Signature = crypto.Sign(privKey, stake.GenTxSignBytes(genDoc.ChainID))
+	if err := stake.VerifyGenTx(genDoc.ChainID); err != nil {
+		return errors.New("the keys don't match: " + err.Error())
+	}
+	genTx := app.GenTx{Stake: &stake}
+	if genTxAddress != "" {
+		genTx.Peer = p2p.IDAddressString(nodeKey.ID(), genTxAddress)
+	}
+	genTxFile := filepath.Join(configuration.RootDir, "config", "gentx", "gentx-"+string(nodeKey.ID())+".json")
+	if err := os.MkdirAll(filepath.Dir(genTxFile), 0700); err != nil {
+		return err
+	}
+	encoded, _ := json.MarshalIndent(genTx, "", "  ")
+	if err := ioutil.WriteFile(genTxFile, encoded, 0644); err != nil {
+		return err
+	}
+	fmt.Println(genTxFile)
+	return nil
+}
+
+// Returns the keys of the account staking, from the keystore or PEM files
+func accountKeys() ([]byte, []byte, error) {
+	if genTxFrom != "" {
+		key, privKey, err := unlockKey(genTxFrom)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key.Type != crypto.KeySecp256k1 {
+			return nil, nil, errors.New(genTxFrom + " isn't a secp256k1 account key")
+		}
+		return privKey, key.PubKey, nil
+	}
+	if genTxKey == "" || genTxPubKey == "" {
+		return nil, nil, errors.New("the account keys are required, set --from or --key and --pubkey")
+	}
+	return crypto.LoadKeys(genTxKey, genTxPubKey)
+}
+
+func collect(cmd *cobra.Command, args []string) error {
+	configuration := loadConfig()
+	genFile := configuration.GenesisFile()
+	genDoc, genesis, err := readGenesis(genFile)
+	if err != nil {
+		return err
+	}
+	if len(genesis.Stakes) > 0 {
+		return errors.New("the genesis already has stakes, collect the gentxs once into a genesis written with init --empty")
+	}
+	dir := genTxDir
+	if dir == "" {
+		dir = filepath.Join(configuration.RootDir, "config", "gentx")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no gentx in " + dir)
+	}
+	sort.Strings(files)
+	nodeKey, err := p2p.LoadNodeKey(configuration.NodeKeyFile())
+	if err != nil {
+		return err
+	}
+	var peers []string
+	for _, file := range files {
+		encoded, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var genTx app.GenTx
+		if err := json.Unmarshal(encoded, &genTx); err != nil {
+			return errors.New(file + ": " + err.Error())
+		}
+		if err := genesis.AddGenTx(genDoc.ChainID, &genTx); err != nil {
+			return errors.New(file + ": " + err.Error())
+		}
+		if genTx.Peer != "" && !strings.HasPrefix(genTx.Peer, string(nodeKey.ID())+"@") {
+			peers = append(peers, genTx.Peer)
+		}
+	}
+
+	genDoc.Validators = genesisValidators(genesis)
+	if err := writeGenesis(genFile, genDoc, genesis); err != nil {
+		return err
+	}
+	if len(peers) > 0 {
+		configuration.P2P.PersistentPeers = strings.Join(peers, ",")
+		config.WriteConfigFile(filepath.Join(configuration.RootDir, "config", "config.toml"), configuration)
+		fmt.Println("persistent_peers = \"" + configuration.P2P.PersistentPeers + "\"")
+	}
+	return nil
+}
+
+func validate(cmd *cobra.Command, args []string) error {
+	genFile := loadConfig().GenesisFile()
+	if len(args) > 0 {
+		genFile = args[0]
+	}
+	genDoc, genesis, err := readGenesis(genFile)
+	if err != nil {
+		return err
+	}
+	validators := make([]tendermint.ValidatorUpdate, len(genDoc.Validators))
+	for i, validator := range genDoc.Validators {
+		validators[i] = types.TM2PB.ValidatorUpdate(types.NewValidator(validator.PubKey, validator.Power))
+	}
+	if err := genesis.Check(genDoc.ChainID, validators); err != nil {
+		return err
+	}
+	fmt.Println(genFile + " is valid")
+	return nil
+}
+
+func readGenesis(genFile string) (*types.GenesisDoc, *app.GenesisState, error) {
+	genDoc, err := types.GenesisDocFromFile(genFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	genesis, err := app.ParseGenesisState(genDoc.AppState)
+	if err != nil {
+		return nil, nil, err
+	}
+	return genDoc, genesis, nil
+}
+
+// Returns the Tendermint genesis validators of the stakes of the genesis
+func genesisValidators(genesis *app.GenesisState) []types.GenesisValidator {
+	var validators []types.GenesisValidator
+	for _, stake := range genesis.Stakes {
+		var validator ed25519.PubKeyEd25519
+		key, _ := hex.DecodeString(stake.Validator)
+		copy(validator[:], key)
+		validators = append(validators, types.GenesisValidator{
+			Address: validator.Address(),
+			PubKey:  validator,
+			Power:   stake.Amount,
+		})
+	}
+	return validators
+}
+
+func writeGenesis(genFile string, genDoc *types.GenesisDoc, genesis *app.GenesisState) error {
+	appState, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
 	}
+	genDoc.AppState = appState
+	return genDoc.SaveAs(genFile)
 }
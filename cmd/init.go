@@ -1,12 +1,14 @@
 package cmd
 
 import (
-	"encoding/hex"
+	"dbc-node/app"
 	"github.com/spf13/cobra"
 	"github.com/tendermint/tendermint/config"
+	"github.com/tendermint/tendermint/crypto/ed25519"
 	"github.com/tendermint/tendermint/p2p"
 	"github.com/tendermint/tendermint/privval"
 	"github.com/tendermint/tendermint/types"
+	"path/filepath"
 	"time"
 )
 
@@ -17,17 +19,40 @@ var InitCmd = &cobra.Command{
 }
 
 func initialize(cmd *cobra.Command, args []string) {
-	configuration := config.DefaultConfig()
-	configuration.SetRoot(rootDir)
-	config.EnsureRoot(configuration.RootDir)
+	configuration := newConfig(rootDir)
+	validatorKey, _ := initNode(configuration)
+
+	genesis := &app.GenesisState{}
+	if !emptyGenesis {
+		genesis = defaultGenesisState(validatorKey)
+	}
+	genDoc := types.GenesisDoc{
+		ChainID:         chainID,
+		GenesisTime:     time.Now(),
+		ConsensusParams: types.DefaultConsensusParams(),
+		Validators:      genesisValidators(genesis),
+	}
+	writeGenesis(configuration.GenesisFile(), &genDoc, genesis)
+}
 
+// Returns the configuration init writes in the home directory
+func newConfig(home string) *config.Config {
+	configuration := config.DefaultConfig()
+	configuration.SetRoot(home)
 	configuration.LogLevel = "consensus:error,*:info"
+	configuration.TxIndex.IndexAllKeys = true // index every event attribute for tx_search
 	configuration.RPC.CORSAllowedOrigins = []string{"*"}
 	configuration.RPC.ListenAddress = "tcp://0.0.0.0:26657"
 	configuration.P2P.AllowDuplicateIP = true
 	configuration.Consensus.CreateEmptyBlocksInterval = time.Duration(10) * time.Second
+	return configuration
+}
+
+// Writes the configuration and generates the keys of a node, returns its validator key and node ID
+func initNode(configuration *config.Config) (ed25519.PubKeyEd25519, p2p.ID) {
+	config.EnsureRoot(configuration.RootDir)
 	configuration.ValidateBasic()
-	config.WriteConfigFile(rootDir+"/config/config.toml", configuration)
+	config.WriteConfigFile(filepath.Join(configuration.RootDir, "config", "config.toml"), configuration)
 
 	privValKeyFile := configuration.PrivValidatorKeyFile()
 	privValStateFile := configuration.PrivValidatorStateFile()
@@ -35,20 +60,6 @@ func initialize(cmd *cobra.Command, args []string) {
 	privVal.Save()
 
 	nodeKeyFile := configuration.NodeKeyFile()
-	p2p.LoadOrGenNodeKey(nodeKeyFile)
-
-	genFile := configuration.GenesisFile()
-	genDoc := types.GenesisDoc{
-		ChainID:         "datablockchain",
-		GenesisTime:     time.Now(),
-		ConsensusParams: types.DefaultConsensusParams(),
-	}
-	for _, key := range genValidatorKeys {
-		genDoc.Validators = append(genDoc.Validators, types.GenesisValidator{
-			Address: key.Address(),
-			PubKey:  key,
-			Power:   genValidators[hex.EncodeToString(key[:])],
-		})
-	}
-	genDoc.SaveAs(genFile)
+	nodeKey, _ := p2p.LoadOrGenNodeKey(nodeKeyFile)
+	return privVal.Key.PubKey.(ed25519.PubKeyEd25519), nodeKey.ID()
 }
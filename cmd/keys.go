@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"bufio"
+	"dbc-node/crypto"
+	"dbc-node/keys"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+The keys commands manage the keystore of the home directory, passphrase encrypted secp256k1 account keys
+and ed25519 validator keys. Account keys are derived from the BIP-39 seed of the keystore, created from a new
+mnemonic or recovered by the first keys add, at their BIP-44 path m/44'/7700'/account'/0/index. Keys are imported from and exported to PEM files as written by openssl.
+Passphrases and mnemonics are read from the terminal, or a line each from the standard input when it isn't one.
+*/
+
+var (
+	keystoreDir string
+	keyType     string
+	keyPem      bool
+	keyRecover  bool
+	keyAccount  uint32
+	keyIndex    uint32
+)
+
+var KeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the keys of the keystore",
+}
+
+var addKeyCmd = &cobra.Command{
+	Use:          "add <name>",
+	Short:        "Generate a key, secp256k1 keys derived from the keystore seed",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         addKey,
+}
+
+var importKeyCmd = &cobra.Command{
+	Use:          "import <name> <private key PEM file>",
+	Short:        "Import a SEC1 or PKCS#8 private key",
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE:         importKey,
+}
+
+var listKeysCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List the keys: name, type and hex public key",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         listKeys,
+}
+
+var showKeyCmd = &cobra.Command{
+	Use:          "show <name>",
+	Short:        "Show the public key of a key",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         showKey,
+}
+
+var exportKeyCmd = &cobra.Command{
+	Use:          "export <name>",
+	Short:        "Print the private key of a key in PEM",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         exportKey,
+}
+
+var deleteKeyCmd = &cobra.Command{
+	Use:          "delete <name>",
+	Short:        "Delete a key",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         deleteKey,
+}
+
+func init() {
+	KeysCmd.AddCommand(addKeyCmd, importKeyCmd, listKeysCmd, showKeyCmd, exportKeyCmd, deleteKeyCmd)
+	KeysCmd.PersistentFlags().StringVar(&keystoreDir, "keystore", "", "Directory of the keystore (default <home>/keystore)")
+	addKeyCmd.Flags().StringVar(&keyType, "type", crypto.KeySecp256k1, "Type of the key, secp256k1 or ed25519")
+	addKeyCmd.Flags().BoolVar(&keyRecover, "recover", false, "Recover the keystore seed from a BIP-39 mnemonic")
+	addKeyCmd.Flags().Uint32Var(&keyAccount, "account", 0, "Account of the BIP-44 path of the key")
+	addKeyCmd.Flags().Uint32Var(&keyIndex, "index", 0, "Index of the BIP-44 path of the key (default the next index of the account)")
+	showKeyCmd.Flags().BoolVar(&keyPem, "pem", false, "Print the public key in PEM")
+}
+
+func addKey(cmd *cobra.Command, args []string) error {
+	if keyType != crypto.KeySecp256k1 {
+		if keyRecover {
+			return errors.New("only secp256k1 keys are derived from a mnemonic")
+		}
+		privKey, _, err := crypto.GenerateKeys(keyType)
+		if err != nil {
+			return err
+		}
+		return storeKey(args[0], keyType, privKey)
+	}
+
+	store, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	if _, err := store.Get(args[0]); err == nil {
+		return keys.ErrExists
+	} else if !errors.Is(err, keys.ErrNotFound) {
+		return err // checked before a new seed is saved
+	}
+	var passphrase string
+	if store.HasSeed() {
+		if keyRecover {
+			return keys.ErrSeedExists
+		}
+		if passphrase, err = readPassphrase("Passphrase of the seed: "); err != nil {
+			return err
+		}
+	} else {
+		var mnemonic string
+		if keyRecover {
+			mnemonic, err = readPassphrase("Mnemonic: ")
+		} else {
+			mnemonic, err = crypto.NewMnemonic()
+		}
+		if err != nil {
+			return err
+		}
+		seed, err := crypto.MnemonicSeed(mnemonic, "")
+		if err != nil {
+			return err
+		}
+		if passphrase, err = newPassphrase("the seed"); err != nil {
+			return err
+		}
+		if err := store.AddSeed(seed, passphrase); err != nil {
+			return err
+		}
+		if !keyRecover { // shown as soon as the seed is saved, whatever happens to the key
+			fmt.Fprintln(os.Stderr, "\nWrite down the mnemonic, it recovers the seed and every key with keys add --recover:\n\n"+mnemonic+"\n")
+		}
+	}
+	index := keyIndex
+	if !cmd.Flags().Changed("index") {
+		if index, err = store.NextIndex(keyAccount); err != nil {
+			return err
+		}
+	}
+	key, err := store.Derive(args[0], keyAccount, index, passphrase)
+	if err != nil {
+		return err
+	}
+	printKey(key)
+	return nil
+}
+
+func importKey(cmd *cobra.Command, args []string) error {
+	pemBytes, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	importedType, privKey, err := crypto.ParsePrivKey(pemBytes)
+	if err != nil {
+		return err
+	}
+	return storeKey(args[0], importedType, privKey)
+}
+
+func storeKey(name, keyType string, privKey []byte) error {
+	store, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	if _, err := store.Get(name); err == nil {
+		return keys.ErrExists
+	}
+	passphrase, err := newPassphrase(name)
+	if err != nil {
+		return err
+	}
+	key, err := store.Add(name, keyType, privKey, passphrase)
+	if err != nil {
+		return err
+	}
+	printKey(key)
+	return nil
+}
+
+// Reads a new passphrase of the name, twice
+func newPassphrase(name string) (string, error) {
+	passphrase, err := readPassphrase("Passphrase of " + name + ": ")
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) < keys.MinPassphrase {
+		return "", keys.ErrWeak
+	}
+	repeated, err := readPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if repeated != passphrase {
+		return "", errors.New("the passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+func listKeys(cmd *cobra.Command, args []string) error {
+	store, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, key := range list {
+		printKey(key)
+	}
+	return nil
+}
+
+func showKey(cmd *cobra.Command, args []string) error {
+	store, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	key, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+	if !keyPem {
+		printKey(key)
+		return nil
+	}
+	pubKeyPem, err := crypto.MarshalPubKey(key.Type, key.PubKey)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(pubKeyPem))
+	return nil
+}
+
+func exportKey(cmd *cobra.Command, args []string) error {
+	key, privKey, err := unlockKey(args[0])
+	if err != nil {
+		return err
+	}
+	privKeyPem, err := crypto.MarshalPrivKey(key.Type, privKey)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(privKeyPem))
+	return nil
+}
+
+func deleteKey(cmd *cobra.Command, args []string) error {
+	store, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	if _, err := store.Get(args[0]); err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase("Passphrase of " + args[0] + ": ")
+	if err != nil {
+		return err
+	}
+	return store.Delete(args[0], passphrase)
+}
+
+// Returns the private key of the name, asking its passphrase
+func unlockKey(name string) (*keys.Key, []byte, error) {
+	store, err := openKeystore()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := store.Get(name); err != nil {
+		return nil, nil, err
+	}
+	passphrase, err := readPassphrase("Passphrase of " + name + ": ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return store.PrivKey(name, passphrase)
+}
+
+func openKeystore() (*keys.Store, error) {
+	dir := keystoreDir
+	if dir == "" {
+		dir = filepath.Join(rootDir, "keystore")
+	}
+	return keys.NewStore(dir)
+}
+
+func printKey(key *keys.Key) {
+	line := key.Name + "\t" + key.Type + "\t" + hex.EncodeToString(key.PubKey)
+	if key.Path != "" {
+		line += "\t" + key.Path
+	}
+	fmt.Println(line)
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Reads a passphrase, or mnemonic, without echo from the terminal, or a line of the standard input, prompting on the standard error
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(passphrase), err
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no passphrase on the standard input")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
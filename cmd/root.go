@@ -2,17 +2,39 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tendermint/tendermint/config"
 )
 
 var rootDir string
+var blobsAddress string
+var chainID string
+var emptyGenesis bool
 
 func init() {
 	RootCmd.AddCommand(InitCmd)
 	RootCmd.AddCommand(RunCmd)
+	RootCmd.AddCommand(GenesisCmd)
+	RootCmd.AddCommand(TestnetCmd)
+	RootCmd.AddCommand(KeysCmd)
 	RootCmd.PersistentFlags().StringVar(&rootDir, "home", "./tmhome", "Home directory of Data Blockchain")
+	InitCmd.Flags().StringVar(&chainID, "chain-id", "datablockchain", "Chain ID of the genesis")
+	InitCmd.Flags().BoolVar(&emptyGenesis, "empty", false, "Write a genesis without accounts and validators, to build with the genesis commands")
+	RunCmd.Flags().StringVar(&blobsAddress, "blobs", "127.0.0.1:26659", "Listen address of the blob store, local only by default, empty to disable it")
 }
 
 var RootCmd = cobra.Command{
 	Use:   "dbc-node",
 	Short: "Data Blockchain node",
 }
+
+// Returns the configuration in the home directory
+func loadConfig() *config.Config {
+	configuration := config.DefaultConfig()
+	viper.SetConfigFile(rootDir + "/config/config.toml")
+	viper.ReadInConfig()
+	viper.Unmarshal(configuration)
+	configuration.SetRoot(rootDir)
+	configuration.ValidateBasic()
+	return configuration
+}
@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"dbc-node/app"
+	"dbc-node/blobs"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"github.com/tendermint/tendermint/config"
 	"github.com/tendermint/tendermint/libs/cli/flags"
 	"github.com/tendermint/tendermint/libs/log"
@@ -11,8 +11,11 @@ import (
 	"github.com/tendermint/tendermint/p2p"
 	"github.com/tendermint/tendermint/privval"
 	"github.com/tendermint/tendermint/proxy"
+	dbm "github.com/tendermint/tm-db"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 )
 
@@ -23,18 +26,36 @@ var RunCmd = &cobra.Command{
 }
 
 func run(cmd *cobra.Command, args []string) {
-	dataBlockChain := app.NewDataBlockChain(genUsers, genValidators)
-
-	configuration := config.DefaultConfig()
-	viper.SetConfigFile(rootDir + "/config/config.toml")
-	viper.ReadInConfig()
-	viper.Unmarshal(configuration)
-	configuration.SetRoot(rootDir)
-	configuration.ValidateBasic()
+	configuration := loadConfig()
 
 	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
 	logger, _ = flags.ParseLogLevel(configuration.LogLevel, logger, config.DefaultLogLevel())
 
+	db := dbm.NewDB("dbc", dbm.BackendType(configuration.DBBackend), configuration.DBDir())
+	dataBlockChain, err := app.NewDataBlockChain(db)
+	if err != nil {
+		logger.Error("Failed loading app state", "err", err)
+		db.Close()
+		os.Exit(1)
+	}
+
+	if blobsAddress != "" {
+		store, err := blobs.NewStore(filepath.Join(configuration.DBDir(), "blobs"), dataBlockChain.ContentSize)
+		if err != nil {
+			logger.Error("Failed opening blob store", "err", err)
+			db.Close()
+			os.Exit(1)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(blobs.Route, store)
+		go func() {
+			logger.Info("Serving blob store", "address", blobsAddress)
+			if err := http.ListenAndServe(blobsAddress, mux); err != nil {
+				logger.Error("Blob store stopped", "err", err)
+			}
+		}()
+	}
+
 	pv := privval.LoadFilePV(
 		configuration.PrivValidatorKeyFile(),
 		configuration.PrivValidatorStateFile(),
@@ -53,13 +74,13 @@ func run(cmd *cobra.Command, args []string) {
 		logger)
 
 	node.Start()
-	defer func() {
-		node.Stop()
-		node.Wait()
-	}()
 
 	sign := make(chan os.Signal, 1)
 	signal.Notify(sign, syscall.SIGINT, syscall.SIGTERM)
 	<-sign
+	// os.Exit skips deferred calls: stop the node, which commits to the db until then, and close the db first
+	node.Stop()
+	node.Wait()
+	db.Close()
 	os.Exit(0)
 }
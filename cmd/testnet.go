@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"dbc-node/app"
+	"dbc-node/crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/tendermint/tendermint/config"
+	"github.com/tendermint/tendermint/p2p"
+	"github.com/tendermint/tendermint/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+The testnet command writes the home directories of a network of validators running on localhost,
+node0 to node<N-1>, and a start.sh script running them all. Node i listens on the starting port + 10i
+for p2p, + 10i + 1 for RPC and + 10i + 3 for the blob store. Every node has a funded account,
+its keys in the keys directory of its home, and the same stake in the shared genesis.
+*/
+
+var (
+	testnetNodes        int
+	testnetOutput       string
+	testnetChainID      string
+	testnetStartingPort int
+)
+
+var TestnetCmd = &cobra.Command{
+	Use:          "testnet",
+	Short:        "Write the home directories and start script of a local network",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         testnet,
+}
+
+func init() {
+	TestnetCmd.Flags().IntVar(&testnetNodes, "nodes", 4, "Number of validator nodes")
+	TestnetCmd.Flags().StringVar(&testnetOutput, "output", "./testnet", "Directory of the node home directories")
+	TestnetCmd.Flags().StringVar(&testnetChainID, "chain-id", "dbc-testnet", "Chain ID of the genesis")
+	TestnetCmd.Flags().IntVar(&testnetStartingPort, "starting-port", 26656, "P2P port of the first node")
+}
+
+func testnet(cmd *cobra.Command, args []string) error {
+	if testnetNodes < 1 {
+		return errors.New("a testnet needs at least a node")
+	}
+	if testnetStartingPort < 1 || testnetStartingPort+10*testnetNodes > 65535 {
+		return errors.New("invalid starting port " + strconv.Itoa(testnetStartingPort))
+	}
+	if _, err := os.Stat(testnetOutput); err == nil {
+		return errors.New(testnetOutput + " already exists")
+	}
+
+	homes := make([]string, testnetNodes)
+	configurations := make([]*config.Config, testnetNodes)
+	peers := make([]string, testnetNodes)
+	genesis := &app.GenesisState{}
+	for i := range homes {
+		homes[i] = filepath.Join(testnetOutput, "node"+strconv.Itoa(i))
+		configuration := newConfig(homes[i])
+		configurations[i] = configuration
+		configuration.Moniker = "node" + strconv.Itoa(i)
+		configuration.P2P.ListenAddress = "tcp://0.0.0.0:" + strconv.Itoa(testnetPort(i, 0))
+		configuration.P2P.AddrBookStrict = false // peers are on localhost
+		configuration.RPC.ListenAddress = "tcp://0.0.0.0:" + strconv.Itoa(testnetPort(i, 1))
+		configuration.ProfListenAddress = ""
+		validatorKey, nodeID := initNode(configuration)
+		peers[i] = p2p.IDAddressString(nodeID, "127.0.0.1:"+strconv.Itoa(testnetPort(i, 0)))
+
+		privKey, pubKey, err := crypto.GenerateKeys(crypto.KeySecp256k1)
+		if err != nil {
+			return err
+		}
+		keys := filepath.Join(homes[i], "keys")
+		if err := os.MkdirAll(keys, 0700); err != nil {
+			return err
+		}
+		if err := crypto.SaveKeys(filepath.Join(keys, "privkey.pem"), filepath.Join(keys, "pubkey.pem"), privKey, pubKey); err != nil {
+			return err
+		}
+		genesis.Accounts = append(genesis.Accounts, app.GenesisAccount{PubKey: hex.EncodeToString(pubKey), Balance: defaultBalance})
+		genesis.Stakes = append(genesis.Stakes, app.GenesisStake{Validator: hex.EncodeToString(validatorKey[:]), Amount: defaultStake})
+	}
+
+	genDoc := types.GenesisDoc{
+		ChainID:         testnetChainID,
+		GenesisTime:     time.Now(),
+		ConsensusParams: types.DefaultConsensusParams(),
+		Validators:      genesisValidators(genesis),
+	}
+	for i, configuration := range configurations {
+		configuration.P2P.PersistentPeers = strings.Join(append(append([]string{}, peers[:i]...), peers[i+1:]...), ",")
+		config.WriteConfigFile(filepath.Join(configuration.RootDir, "config", "config.toml"), configuration)
+		if err := writeGenesis(configuration.GenesisFile(), &genDoc, genesis); err != nil {
+			return err
+		}
+	}
+
+	script := filepath.Join(testnetOutput, "start.sh")
+	if err := ioutil.WriteFile(script, []byte(startScript(homes)), 0755); err != nil {
+		return err
+	}
+	fmt.Println(script)
+	return nil
+}
+
+// Returns a port of node i: p2p is offset 0, RPC 1 and the blob store 3, as the default ports
+func testnetPort(node, offset int) int {
+	return testnetStartingPort + 10*node + offset
+}
+
+// Returns the script starting every node in the background, logging to node.log in its home, and stopping them on exit
+func startScript(homes []string) string {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = "dbc-node"
+	}
+	script := "#!/bin/sh\n" +
+		"# Starts the nodes of the testnet, stop them with Ctrl-C, set DBC_NODE to run another binary\n" +
+		"cd \"$(dirname \"$0\")\"\n" +
+		"DBC_NODE=\"${DBC_NODE:-" + executable + "}\"\n" +
+		"trap 'kill $(jobs -p) 2>/dev/null' INT TERM\n"
+	for i, home := range homes {
+		name := filepath.Base(home)
+		script += "\"$DBC_NODE\" run --home " + name + " --blobs 127.0.0.1:" + strconv.Itoa(testnetPort(i, 3)) +
+			" > " + name + "/node.log 2>&1 &\n"
+		script += "echo \"" + name + ": rpc http://127.0.0.1:" + strconv.Itoa(testnetPort(i, 1)) + ", log " + name + "/node.log\"\n"
+	}
+	return script + "wait\n"
+}
@@ -3,29 +3,12 @@ package crypto
 import (
 	"crypto/ed25519"
 	"crypto/sha256"
-	"encoding/pem"
 	"errors"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/tendermint/tendermint/crypto"
 	"io/ioutil"
 )
 
-const (
-	privateKeyStart = 7
-	privateKeyEnd   = 39
-	publicKeyStart  = 23
-)
-
-func LoadKeys(privKeyFile, pubKeyFile string) (privKey []byte, pubKey []byte) {
-	privKeyPem, _ := ioutil.ReadFile(privKeyFile)
-	pubKeyPem, _ := ioutil.ReadFile(pubKeyFile)
-	privKeyBlock, _ := pem.Decode(privKeyPem)
-	pubKeyBlock, _ := pem.Decode(pubKeyPem)
-	privKey = privKeyBlock.Bytes[privateKeyStart:privateKeyEnd]
-	pubKey = pubKeyBlock.Bytes[publicKeyStart:]
-	return
-}
-
 func LoadSignature(signatureFile string) (signature []byte) {
 	signature, _ = ioutil.ReadFile(signatureFile)
 	return
@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+)
+
+/*
+ECIES over secp256k1 with AES-256-GCM: a ciphertext is ephemeral public key (33 bytes, compressed) || nonce (12 bytes) ||
+sealed data. The AES key is sha256(shared secret || ephemeral public key), the shared secret is the x coordinate
+of the ECDH of the ephemeral key with the recipient key.
+*/
+
+var ErrDecrypt = errors.New("can't decrypt ciphertext")
+
+// Encrypts the plaintext for the owner of the secp256k1 public key
+func Encrypt(pubKey, plaintext []byte) ([]byte, error) {
+	recipient, err := btcec.ParsePubKey(pubKey, btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub := ephemeral.PubKey().SerializeCompressed()
+	aead, err := newAEAD(btcec.GenerateSharedSecret(ephemeral, recipient), ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := append(ephemeralPub, nonce...)
+	return aead.Seal(ciphertext, nonce, plaintext, nil), nil
+}
+
+// Decrypts a ciphertext of Encrypt with the secp256k1 private key of the recipient
+func Decrypt(privKey, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < pointLength {
+		return nil, ErrDecrypt
+	}
+	ephemeral, err := btcec.ParsePubKey(ciphertext[:pointLength], btcec.S256())
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	recipient, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKey)
+	aead, err := newAEAD(btcec.GenerateSharedSecret(recipient, ephemeral), ciphertext[:pointLength])
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < pointLength+aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce := ciphertext[pointLength : pointLength+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, ciphertext[pointLength+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Decrypts a ciphertext with the private key of its recipient and encrypts the plaintext for another public key,
+// used by the acceptor to pass the payload data to the requirer
+func Reencrypt(privKey, ciphertext, pubKey []byte) ([]byte, error) {
+	plaintext, err := Decrypt(privKey, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return Encrypt(pubKey, plaintext)
+}
+
+// Returns the commitment to a plaintext, published next to its ciphertexts so that anyone decrypting one of them
+// can check it's the same plaintext. A plaintext that could be guessed should be salted before encryption.
+func Commitment(plaintext []byte) []byte {
+	hash := sha256.Sum256(plaintext)
+	return hash[:]
+}
+
+func newAEAD(sharedSecret, ephemeralPub []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(append(sharedSecret, ephemeralPub...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
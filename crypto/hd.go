@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/cosmos/go-bip39"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+Hierarchical deterministic secp256k1 accounts: a BIP-39 mnemonic gives a seed, BIP-32 derives the private keys
+of the seed along a path, and BIP-44 fixes the path of the accounts to m/44'/CoinType'/account'/0/index.
+One mnemonic backs up every key derived from it, e.g. a requirer can use a new index for every data request
+so that its requests can't be linked by their key.
+*/
+
+const (
+	CoinType        = 7700 // DBC coin type of the BIP-44 paths
+	MnemonicEntropy = 256  // bits of a new mnemonic, 24 words
+
+	hardened = 1 << 31
+)
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// Returns a new mnemonic of 24 words
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(MnemonicEntropy)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// Returns the BIP-39 seed of the mnemonic, its checksum verified, and an optional passphrase
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMnemonic, err.Error())
+	}
+	return seed, nil
+}
+
+// Returns the BIP-44 path of a DBC account key
+func AccountPath(account, index uint32) string {
+	return fmt.Sprintf("m/44'/%d'/%d'/0/%d", CoinType, account, index)
+}
+
+// Returns the secp256k1 key pair of the seed at the BIP-32 path, e.g. m/44'/7700'/0'/0/0
+func DeriveKeys(seed []byte, path string) (privKey []byte, pubKey []byte, err error) {
+	indexes, err := parsePath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	privKey, chainCode := sum[:32], sum[32:]
+	if checkScalar(privKey) != nil {
+		return nil, nil, errors.New("seed without master key")
+	}
+	for _, index := range indexes {
+		if privKey, chainCode, err = deriveChild(privKey, chainCode, index); err != nil {
+			return nil, nil, err
+		}
+	}
+	pubKey, err = PubKey(KeySecp256k1, privKey)
+	return privKey, pubKey, err
+}
+
+// BIP-32 private parent key to private child key
+func deriveChild(privKey, chainCode []byte, index uint32) ([]byte, []byte, error) {
+	data := make([]byte, 0, 37)
+	if index >= hardened {
+		data = append(append(data, 0), privKey...)
+	} else {
+		_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKey)
+		data = append(data, pubKey.SerializeCompressed()...)
+	}
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], index)
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(btcec.S256().N) >= 0 {
+		return nil, nil, errors.New("invalid child key, skip index " + strconv.FormatUint(uint64(index), 10))
+	}
+	child := tweak.Add(tweak, new(big.Int).SetBytes(privKey))
+	child.Mod(child, btcec.S256().N)
+	if child.Sign() == 0 {
+		return nil, nil, errors.New("invalid child key, skip index " + strconv.FormatUint(uint64(index), 10))
+	}
+	childKey := make([]byte, 32)
+	childBytes := child.Bytes()
+	copy(childKey[32-len(childBytes):], childBytes)
+	return childKey, sum[32:], nil
+}
+
+// Returns the indexes of a path m/i/j'/..., hardened indexes marked by ' or h
+func parsePath(path string) ([]uint32, error) {
+	levels := strings.Split(path, "/")
+	if levels[0] != "m" {
+		return nil, fmt.Errorf("%w: %s doesn't start with m", ErrInvalidPath, path)
+	}
+	var indexes []uint32
+	for _, level := range levels[1:] {
+		var offset uint32
+		if strings.HasSuffix(level, "'") || strings.HasSuffix(level, "h") {
+			offset = hardened
+			level = level[:len(level)-1]
+		}
+		index, err := strconv.ParseUint(level, 10, 32)
+		if err != nil || index >= hardened {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+	return indexes, nil
+}
@@ -0,0 +1,344 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"io/ioutil"
+	"math/big"
+)
+
+/*
+Keys are read from and written to PEM files as openssl does: secp256k1 private keys in SEC1 (EC PRIVATE KEY)
+or PKCS#8 (PRIVATE KEY), ed25519 private keys in PKCS#8, public keys in PKIX (PUBLIC KEY).
+In memory a secp256k1 private key is its 32 byte scalar and its public key is uncompressed (65 bytes),
+an ed25519 private key is seed || public key (64 bytes), as crypto/ed25519, and its public key 32 bytes.
+*/
+
+// Key types
+const (
+	KeySecp256k1 = "secp256k1"
+	KeyEd25519   = "ed25519"
+)
+
+var ErrInvalidKey = errors.New("invalid key")
+
+var (
+	oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1   = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+	oidEd25519     = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// SEC1 private key, RFC 5915
+type ecPrivateKey struct {
+	Version    int
+	PrivateKey []byte
+	Curve      asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey  asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// PKCS#8 private key, RFC 5208, without attributes
+type pkcs8 struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// Subject public key info, RFC 5280
+type publicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// Reads a secp256k1 key pair from PEM files, the public key must be the one of the private key
+func LoadKeys(privKeyFile, pubKeyFile string) (privKey []byte, pubKey []byte, err error) {
+	privKeyPem, err := ioutil.ReadFile(privKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKeyPem, err := ioutil.ReadFile(pubKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	privKeyType, privKey, err := ParsePrivKey(privKeyPem)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKeyType, pubKey, err := ParsePubKey(pubKeyPem)
+	if err != nil {
+		return nil, nil, err
+	}
+	if privKeyType != KeySecp256k1 || pubKeyType != KeySecp256k1 {
+		return nil, nil, keyError("not a secp256k1 key pair")
+	}
+	if derived, _ := PubKey(privKeyType, privKey); !bytes.Equal(derived, pubKey) {
+		return nil, nil, keyError("the public key isn't the one of the private key")
+	}
+	return privKey, pubKey, nil
+}
+
+// Writes a secp256k1 key pair to PEM files, as openssl does
+func SaveKeys(privKeyFile, pubKeyFile string, privKey, pubKey []byte) error {
+	privKeyPem, err := MarshalPrivKey(KeySecp256k1, privKey)
+	if err != nil {
+		return err
+	}
+	pubKeyPem, err := MarshalPubKey(KeySecp256k1, pubKey)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(privKeyFile, privKeyPem, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pubKeyFile, pubKeyPem, 0644)
+}
+
+// Returns a new key pair of the type
+func GenerateKeys(keyType string) (privKey []byte, pubKey []byte, err error) {
+	switch keyType {
+	case KeySecp256k1:
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			return nil, nil, err
+		}
+		return key.Serialize(), key.PubKey().SerializeUncompressed(), nil
+	case KeyEd25519:
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, pubKey, err
+	default:
+		return nil, nil, keyError("unknown key type " + keyType)
+	}
+}
+
+// Returns the public key of a private key of the type
+func PubKey(keyType string, privKey []byte) ([]byte, error) {
+	switch keyType {
+	case KeySecp256k1:
+		if err := checkScalar(privKey); err != nil {
+			return nil, err
+		}
+		_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKey)
+		return pubKey.SerializeUncompressed(), nil
+	case KeyEd25519:
+		if len(privKey) != ed25519.PrivateKeySize {
+			return nil, keyError("ed25519 private key length")
+		}
+		return ed25519.PrivateKey(privKey).Public().(ed25519.PublicKey), nil
+	default:
+		return nil, keyError("unknown key type " + keyType)
+	}
+}
+
+// Decodes the first private key of a PEM file, skipping the EC PARAMETERS written by openssl ecparam
+func ParsePrivKey(pemBytes []byte) (keyType string, privKey []byte, err error) {
+	block, err := decodePem(pemBytes, "EC PRIVATE KEY", "PRIVATE KEY")
+	if err != nil {
+		return "", nil, err
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		privKey, err = parseECPrivateKey(block.Bytes, nil)
+		return KeySecp256k1, privKey, err
+	}
+	var key pkcs8
+	if err := unmarshal(block.Bytes, &key); err != nil {
+		return "", nil, err
+	}
+	switch {
+	case key.Algorithm.Algorithm.Equal(oidECPublicKey):
+		var curve asn1.ObjectIdentifier
+		if err := unmarshal(key.Algorithm.Parameters.FullBytes, &curve); err != nil {
+			return "", nil, err
+		}
+		privKey, err = parseECPrivateKey(key.PrivateKey, curve)
+		return KeySecp256k1, privKey, err
+	case key.Algorithm.Algorithm.Equal(oidEd25519):
+		var seed []byte
+		if err := unmarshal(key.PrivateKey, &seed); err != nil {
+			return "", nil, err
+		}
+		if len(seed) != ed25519.SeedSize {
+			return "", nil, keyError("ed25519 seed length")
+		}
+		return KeyEd25519, ed25519.NewKeyFromSeed(seed), nil
+	default:
+		return "", nil, keyError("unsupported algorithm " + key.Algorithm.Algorithm.String())
+	}
+}
+
+// Decodes the first public key of a PEM file
+func ParsePubKey(pemBytes []byte) (keyType string, pubKey []byte, err error) {
+	block, err := decodePem(pemBytes, "PUBLIC KEY")
+	if err != nil {
+		return "", nil, err
+	}
+	var key publicKeyInfo
+	if err := unmarshal(block.Bytes, &key); err != nil {
+		return "", nil, err
+	}
+	switch {
+	case key.Algorithm.Algorithm.Equal(oidECPublicKey):
+		var curve asn1.ObjectIdentifier
+		if err := unmarshal(key.Algorithm.Parameters.FullBytes, &curve); err != nil {
+			return "", nil, err
+		}
+		if !curve.Equal(oidSecp256k1) {
+			return "", nil, keyError("unsupported curve " + curve.String())
+		}
+		parsed, err := btcec.ParsePubKey(key.PublicKey.RightAlign(), btcec.S256())
+		if err != nil {
+			return "", nil, keyError(err.Error())
+		}
+		return KeySecp256k1, parsed.SerializeUncompressed(), nil
+	case key.Algorithm.Algorithm.Equal(oidEd25519):
+		if key.PublicKey.BitLength != 8*ed25519.PublicKeySize {
+			return "", nil, keyError("ed25519 public key length")
+		}
+		return KeyEd25519, key.PublicKey.Bytes, nil
+	default:
+		return "", nil, keyError("unsupported algorithm " + key.Algorithm.Algorithm.String())
+	}
+}
+
+// Encodes a private key of the type to PEM, SEC1 for secp256k1 and PKCS#8 for ed25519
+func MarshalPrivKey(keyType string, privKey []byte) ([]byte, error) {
+	pubKey, err := PubKey(keyType, privKey)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case KeySecp256k1:
+		der, err := asn1.Marshal(ecPrivateKey{
+			Version:    1,
+			PrivateKey: privKey,
+			Curve:      oidSecp256k1,
+			PublicKey:  asn1.BitString{Bytes: pubKey, BitLength: 8 * len(pubKey)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	default:
+		seed, _ := asn1.Marshal(ed25519.PrivateKey(privKey).Seed())
+		der, err := asn1.Marshal(pkcs8{
+			Algorithm:  pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			PrivateKey: seed,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+}
+
+// Encodes a public key of the type to PEM
+func MarshalPubKey(keyType string, pubKey []byte) ([]byte, error) {
+	var algorithm pkix.AlgorithmIdentifier
+	switch keyType {
+	case KeySecp256k1:
+		parsed, err := btcec.ParsePubKey(pubKey, btcec.S256())
+		if err != nil {
+			return nil, keyError(err.Error())
+		}
+		pubKey = parsed.SerializeUncompressed()
+		curve, _ := asn1.Marshal(oidSecp256k1)
+		algorithm = pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey, Parameters: asn1.RawValue{FullBytes: curve}}
+	case KeyEd25519:
+		if len(pubKey) != ed25519.PublicKeySize {
+			return nil, keyError("ed25519 public key length")
+		}
+		algorithm = pkix.AlgorithmIdentifier{Algorithm: oidEd25519}
+	default:
+		return nil, keyError("unknown key type " + keyType)
+	}
+	der, err := asn1.Marshal(publicKeyInfo{
+		Algorithm: algorithm,
+		PublicKey: asn1.BitString{Bytes: pubKey, BitLength: 8 * len(pubKey)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Returns the first block of one of the types, encrypted blocks aren't supported
+func decodePem(pemBytes []byte, types ...string) (*pem.Block, error) {
+	for {
+		block, rest := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, keyError("no " + types[0] + " PEM block")
+		}
+		for _, blockType := range types {
+			if block.Type != blockType {
+				continue
+			}
+			if _, encrypted := block.Headers["Proc-Type"]; encrypted {
+				return nil, keyError("encrypted PEM blocks aren't supported")
+			}
+			return block, nil
+		}
+		pemBytes = rest
+	}
+}
+
+// Parses a SEC1 private key, the curve is given by the PKCS#8 algorithm parameters if any
+func parseECPrivateKey(der []byte, curve asn1.ObjectIdentifier) ([]byte, error) {
+	var key ecPrivateKey
+	if err := unmarshal(der, &key); err != nil {
+		return nil, err
+	}
+	if key.Version != 1 {
+		return nil, keyError(fmt.Sprintf("EC private key version %d", key.Version))
+	}
+	if len(key.Curve) > 0 {
+		curve = key.Curve
+	}
+	if !curve.Equal(oidSecp256k1) {
+		return nil, keyError("unsupported curve " + curve.String())
+	}
+	if len(key.PrivateKey) > btcec.PrivKeyBytesLen {
+		return nil, keyError("secp256k1 private key length")
+	}
+	privKey := make([]byte, btcec.PrivKeyBytesLen)
+	copy(privKey[btcec.PrivKeyBytesLen-len(key.PrivateKey):], key.PrivateKey)
+	pubKey, err := PubKey(KeySecp256k1, privKey)
+	if err != nil {
+		return nil, err
+	}
+	if key.PublicKey.BitLength > 0 {
+		parsed, err := btcec.ParsePubKey(key.PublicKey.RightAlign(), btcec.S256())
+		if err != nil || !bytes.Equal(parsed.SerializeUncompressed(), pubKey) {
+			return nil, keyError("the public key isn't the one of the private key")
+		}
+	}
+	return privKey, nil
+}
+
+func checkScalar(privKey []byte) error {
+	scalar := new(big.Int).SetBytes(privKey)
+	if len(privKey) != btcec.PrivKeyBytesLen || scalar.Sign() == 0 || scalar.Cmp(btcec.S256().N) >= 0 {
+		return keyError("secp256k1 private key out of range")
+	}
+	return nil
+}
+
+// Decodes a DER value, with no trailing data
+func unmarshal(der []byte, value interface{}) error {
+	rest, err := asn1.Unmarshal(der, value)
+	if err != nil {
+		return keyError(err.Error())
+	}
+	if len(rest) > 0 {
+		return keyError("trailing data after the ASN.1 value")
+	}
+	return nil
+}
+
+func keyError(detail string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidKey, detail)
+}
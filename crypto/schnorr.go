@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"github.com/btcsuite/btcd/btcec"
+	"math/big"
+)
+
+/*
+Schnorr proof of knowledge over secp256k1: the prover knows the secret scalar x of the point X = x*G.
+The proof is R || s, with R = k*G for a random k, c = sha256(X || R || context) and s = k + c*x mod n,
+it's verified by s*G == R + c*X. The secret isn't revealed and, being bound to the context,
+the proof can't be reused for another one.
+*/
+
+const (
+	pointLength  = 33 // compressed
+	scalarLength = 32
+	// ProofLength is the length of a proof of knowledge
+	ProofLength = pointLength + scalarLength
+)
+
+// Returns the compressed point of the secret, to be published in place of the secret
+func KnowledgePoint(secret []byte) []byte {
+	curve := btcec.S256()
+	x := new(big.Int).Mod(new(big.Int).SetBytes(secret), curve.N)
+	pointX, pointY := curve.ScalarBaseMult(x.Bytes())
+	return (&btcec.PublicKey{Curve: curve, X: pointX, Y: pointY}).SerializeCompressed()
+}
+
+// Proves knowledge of the secret of KnowledgePoint(secret), bound to the context
+func ProveKnowledge(secret, context []byte) ([]byte, error) {
+	curve := btcec.S256()
+	x := new(big.Int).Mod(new(big.Int).SetBytes(secret), curve.N)
+	k, err := rand.Int(rand.Reader, curve.N)
+	if err != nil {
+		return nil, err
+	}
+	nonceX, nonceY := curve.ScalarBaseMult(k.Bytes())
+	nonce := (&btcec.PublicKey{Curve: curve, X: nonceX, Y: nonceY}).SerializeCompressed()
+	c := challenge(KnowledgePoint(secret), nonce, context)
+	s := new(big.Int).Mul(c, x)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+	proof := make([]byte, ProofLength)
+	copy(proof, nonce)
+	sBytes := s.Bytes()
+	copy(proof[ProofLength-len(sBytes):], sBytes) // left padded
+	return proof, nil
+}
+
+func VerifyKnowledge(point, context, proof []byte) bool {
+	curve := btcec.S256()
+	if len(proof) != ProofLength {
+		return false
+	}
+	public, err := btcec.ParsePubKey(point, curve)
+	if err != nil {
+		return false
+	}
+	nonce, err := btcec.ParsePubKey(proof[:pointLength], curve)
+	if err != nil {
+		return false
+	}
+	s := new(big.Int).SetBytes(proof[pointLength:])
+	if s.Cmp(curve.N) >= 0 {
+		return false
+	}
+	c := challenge(point, proof[:pointLength], context)
+	leftX, leftY := curve.ScalarBaseMult(s.Bytes())
+	cX, cY := curve.ScalarMult(public.X, public.Y, c.Bytes())
+	rightX, rightY := curve.Add(nonce.X, nonce.Y, cX, cY)
+	return leftX.Cmp(rightX) == 0 && leftY.Cmp(rightY) == 0
+}
+
+func CheckKnowledgePoint(point []byte) error {
+	_, err := btcec.ParsePubKey(point, btcec.S256())
+	return err
+}
+
+func challenge(point, nonce, context []byte) *big.Int {
+	hash := sha256.New()
+	hash.Write(point)
+	hash.Write(nonce)
+	hash.Write(context)
+	c := new(big.Int).SetBytes(hash.Sum(nil))
+	return c.Mod(c, btcec.S256().N)
+}
@@ -4,8 +4,11 @@ go 1.14
 
 require (
 	github.com/btcsuite/btcd v0.20.1-beta
+	github.com/cosmos/go-bip39 v0.0.0-20180819234021-555e2067c45d
 	github.com/drhodes/golorem v0.0.0-20160418191928-ecccc744c2d9
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.7.0
 	github.com/tendermint/tendermint v0.33.5
+	github.com/tendermint/tm-db v0.5.1
+	golang.org/x/crypto v0.0.0-20200406173513-056763e48d71
 )
@@ -0,0 +1,343 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"dbc-node/crypto"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+The keystore keeps named secp256k1 account keys and ed25519 validator keys in a directory, one JSON file per key.
+The public key is in clear, the private key is sealed with AES-256-GCM under a key derived from a passphrase
+with scrypt, the name and public key being the additional data: a file can't be renamed or have its public key changed.
+It also keeps one BIP-39 seed, sealed the same way in the seed file, created or recovered once:
+account keys derived from it are sealed under its passphrase and keep their BIP-44 path.
+*/
+
+const (
+	MinPassphrase = 8
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	seedName = "seed" // file of the seed, without the .json of the keys
+	seedType = "bip39-seed"
+)
+
+var (
+	ErrInvalidName = errors.New("invalid key name")
+	ErrExists      = errors.New("key already exists")
+	ErrNotFound    = errors.New("key not found")
+	ErrPassphrase  = errors.New("wrong passphrase")
+	ErrWeak        = errors.New("passphrase shorter than 8 characters")
+	ErrSeedExists  = errors.New("keystore already has a seed")
+	ErrNoSeed      = errors.New("keystore has no seed")
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+type Key struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	PubKey []byte `json:"pub_key"`
+	Path   string `json:"path,omitempty"` // BIP-44 path of a key derived from the seed
+}
+
+// File of a key, binary values hex encoded
+type keyFile struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PubKey     string `json:"pub_key"`
+	Path       string `json:"path,omitempty"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Seals the private key of the type under the passphrase and saves it with the name
+func (store *Store) Add(name, keyType string, privKey []byte, passphrase string) (*Key, error) {
+	return store.add(name, keyType, privKey, "", passphrase)
+}
+
+func (store *Store) add(name, keyType string, privKey []byte, path, passphrase string) (*Key, error) {
+	if !validName.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+	if len(passphrase) < MinPassphrase {
+		return nil, ErrWeak
+	}
+	pubKey, err := crypto.PubKey(keyType, privKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(store.path(name)); err == nil {
+		return nil, ErrExists
+	}
+	file := keyFile{Name: name, Type: keyType, PubKey: hex.EncodeToString(pubKey), Path: path}
+	if err := file.seal(privKey, passphrase); err != nil {
+		return nil, err
+	}
+	if err := file.write(store.path(name)); err != nil {
+		return nil, err
+	}
+	return file.key()
+}
+
+// Seals the BIP-39 seed of the keystore under the passphrase, a keystore has only one
+func (store *Store) AddSeed(seed []byte, passphrase string) error {
+	if len(passphrase) < MinPassphrase {
+		return ErrWeak
+	}
+	if store.HasSeed() {
+		return ErrSeedExists
+	}
+	file := keyFile{Name: seedName, Type: seedType}
+	if err := file.seal(seed, passphrase); err != nil {
+		return err
+	}
+	return file.write(filepath.Join(store.dir, seedName))
+}
+
+func (store *Store) HasSeed() bool {
+	_, err := os.Stat(filepath.Join(store.dir, seedName))
+	return err == nil
+}
+
+// Returns the seed of the keystore, unsealed with the passphrase
+func (store *Store) Seed(passphrase string) ([]byte, error) {
+	encoded, err := ioutil.ReadFile(filepath.Join(store.dir, seedName))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSeed
+	} else if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(encoded, &file); err != nil || file.Name != seedName || file.Type != seedType {
+		return nil, errors.New("invalid seed file")
+	}
+	return file.open(passphrase)
+}
+
+// Derives the secp256k1 key of the seed at the BIP-44 path of the account and index, saved with the name under the seed passphrase
+func (store *Store) Derive(name string, account, index uint32, passphrase string) (*Key, error) {
+	if _, err := os.Stat(store.path(name)); err == nil {
+		return nil, ErrExists
+	}
+	seed, err := store.Seed(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	path := crypto.AccountPath(account, index)
+	privKey, _, err := crypto.DeriveKeys(seed, path)
+	if err != nil {
+		return nil, err
+	}
+	return store.add(name, crypto.KeySecp256k1, privKey, path, passphrase)
+}
+
+// Returns the index following the indexes of the keys derived for the account, 0 if none
+func (store *Store) NextIndex(account uint32) (uint32, error) {
+	keys, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	prefix := strings.TrimSuffix(crypto.AccountPath(account, 0), "0")
+	var next uint32
+	for _, key := range keys {
+		if !strings.HasPrefix(key.Path, prefix) {
+			continue
+		}
+		if index, err := strconv.ParseUint(strings.TrimPrefix(key.Path, prefix), 10, 32); err == nil && uint32(index) >= next {
+			next = uint32(index) + 1
+		}
+	}
+	return next, nil
+}
+
+// Returns the keys sorted by name
+func (store *Store) List() ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var keys []*Key
+	for _, path := range paths {
+		key, err := store.Get(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Returns the public key of the name
+func (store *Store) Get(name string) (*Key, error) {
+	file, err := store.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return file.key()
+}
+
+// Returns the private key of the name, unsealed with the passphrase
+func (store *Store) PrivKey(name, passphrase string) (*Key, []byte, error) {
+	file, err := store.read(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := file.key()
+	if err != nil {
+		return nil, nil, err
+	}
+	privKey, err := file.open(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, privKey, nil
+}
+
+// Deletes the key of the name, the passphrase proving it's the owner deleting it
+func (store *Store) Delete(name, passphrase string) error {
+	if _, _, err := store.PrivKey(name, passphrase); err != nil {
+		return err
+	}
+	return os.Remove(store.path(name))
+}
+
+func (store *Store) read(name string) (*keyFile, error) {
+	if !validName.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+	encoded, err := ioutil.ReadFile(store.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(encoded, &file); err != nil {
+		return nil, errors.New("invalid key " + name + ": " + err.Error())
+	}
+	if file.Name != name {
+		return nil, errors.New("invalid key " + name + ": file of key " + file.Name)
+	}
+	return &file, nil
+}
+
+func (store *Store) path(name string) string {
+	return filepath.Join(store.dir, name+".json")
+}
+
+func (file *keyFile) key() (*Key, error) {
+	pubKey, err := hex.DecodeString(file.PubKey)
+	if err != nil || (file.Type != crypto.KeySecp256k1 && file.Type != crypto.KeyEd25519) {
+		return nil, errors.New("invalid key " + file.Name)
+	}
+	return &Key{Name: file.Name, Type: file.Type, PubKey: pubKey, Path: file.Path}, nil
+}
+
+// Seals the secret under the passphrase, with a new salt and nonce
+func (file *keyFile) seal(secret []byte, passphrase string) error {
+	file.KDF, file.N, file.R, file.P = "scrypt", scryptN, scryptR, scryptP
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	file.Salt = hex.EncodeToString(salt)
+	aead, err := file.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	file.Nonce = hex.EncodeToString(nonce)
+	file.Ciphertext = hex.EncodeToString(aead.Seal(nil, nonce, secret, file.additionalData()))
+	return nil
+}
+
+func (file *keyFile) open(passphrase string) ([]byte, error) {
+	nonce, err := hex.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, errors.New("invalid nonce in key " + file.Name)
+	}
+	ciphertext, err := hex.DecodeString(file.Ciphertext)
+	if err != nil {
+		return nil, errors.New("invalid ciphertext in key " + file.Name)
+	}
+	aead, err := file.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce in key " + file.Name)
+	}
+	secret, err := aead.Open(nil, nonce, ciphertext, file.additionalData())
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return secret, nil
+}
+
+func (file *keyFile) write(path string) error {
+	encoded, _ := json.MarshalIndent(file, "", "  ")
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, encoded, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+func (file *keyFile) aead(passphrase string) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(file.Salt)
+	if err != nil || file.KDF != "scrypt" {
+		return nil, errors.New("invalid key derivation in key " + file.Name)
+	}
+	secret, err := scrypt.Key([]byte(passphrase), salt, file.N, file.R, file.P, 32)
+	if err != nil {
+		return nil, errors.New("invalid key derivation in key " + file.Name + ": " + err.Error())
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (file *keyFile) additionalData() []byte {
+	if file.Path != "" {
+		return []byte(file.Name + "/" + file.Type + "/" + file.PubKey + "/" + file.Path)
+	}
+	return []byte(file.Name + "/" + file.Type + "/" + file.PubKey)
+}
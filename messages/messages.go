@@ -2,17 +2,25 @@ package messages
 
 import (
 	"dbc-node/modules"
+	"strconv"
+	"strings"
 )
 
 type TransactionType string
 
 const (
-	TxAddData       TransactionType = "TxAddData"
-	TxAddValidation TransactionType = "TxAddValidation"
-	TxAddPayload    TransactionType = "TxAddPayload"
-	TxAcceptPayload TransactionType = "TxAcceptPayload"
-	TxTransfer      TransactionType = "TxTransfer"
-	TxStake         TransactionType = "TxStake"
+	TxAddData        TransactionType = modules.TypeAddData
+	TxAddValidation  TransactionType = modules.TypeAddValidation
+	TxAddPayload     TransactionType = modules.TypeAddPayload
+	TxAcceptPayload  TransactionType = modules.TypeAcceptPayload
+	TxRejectPayload  TransactionType = modules.TypeRejectPayload
+	TxCloseData      TransactionType = modules.TypeCloseData
+	TxOpenDispute    TransactionType = modules.TypeOpenDispute
+	TxResolveDispute TransactionType = modules.TypeResolveDispute
+	TxRevealPayload  TransactionType = modules.TypeRevealPayload
+	TxRegisterSchema TransactionType = modules.TypeRegisterSchema
+	TxTransfer       TransactionType = modules.TypeTransfer
+	TxStake          TransactionType = modules.TypeStake
 )
 
 type Transaction struct {
@@ -22,6 +30,12 @@ type Transaction struct {
 	Validation      *modules.Validation
 	Payload         *modules.Payload
 	AcceptedPayload *modules.AcceptedPayload
+	RejectedPayload *modules.RejectedPayload
+	CloseData       *modules.CloseData
+	Dispute         *modules.Dispute
+	Resolution      *modules.Resolution
+	Reveal          *modules.Reveal
+	Schema          *modules.Schema
 	Transfer        *modules.Transfer
 	Stake           *modules.Stake
 
@@ -29,22 +43,161 @@ type Transaction struct {
 	VersionIndex int
 }
 
-type QueryType string
+// Checks the transaction type is known and carries the content it needs
+func (transaction *Transaction) Check() error {
+	var missing bool
+	switch transaction.TxType {
+	case TxAddData:
+		missing = transaction.Description == nil
+	case TxAddValidation:
+		missing = transaction.Validation == nil
+	case TxAddPayload:
+		missing = transaction.Payload == nil
+	case TxAcceptPayload:
+		missing = transaction.AcceptedPayload == nil
+	case TxRejectPayload:
+		missing = transaction.RejectedPayload == nil
+	case TxCloseData:
+		missing = transaction.CloseData == nil
+	case TxOpenDispute:
+		missing = transaction.Dispute == nil
+	case TxResolveDispute:
+		missing = transaction.Resolution == nil
+	case TxRevealPayload:
+		missing = transaction.Reveal == nil
+	case TxRegisterSchema:
+		missing = transaction.Schema == nil
+	case TxTransfer:
+		missing = transaction.Transfer == nil
+	case TxStake:
+		missing = transaction.Stake == nil
+	default:
+		return modules.ErrUnknownTxType.Wrap(string(transaction.TxType))
+	}
+	if missing {
+		return modules.ErrMissingContent.Wrap(string(transaction.TxType))
+	}
+	return nil
+}
+
+// Returns the signed content of a checked transaction
+func (transaction *Transaction) Message() modules.Message {
+	switch transaction.TxType {
+	case TxAddData:
+		return transaction.Description
+	case TxAddValidation:
+		return transaction.Validation
+	case TxAddPayload:
+		return transaction.Payload
+	case TxAcceptPayload:
+		return transaction.AcceptedPayload
+	case TxRejectPayload:
+		return transaction.RejectedPayload
+	case TxCloseData:
+		return transaction.CloseData
+	case TxOpenDispute:
+		return transaction.Dispute
+	case TxResolveDispute:
+		return transaction.Resolution
+	case TxRevealPayload:
+		return transaction.Reveal
+	case TxRegisterSchema:
+		return transaction.Schema
+	case TxTransfer:
+		return transaction.Transfer
+	case TxStake:
+		return transaction.Stake
+	}
+	return nil
+}
 
+// Query paths, segments in braces are parameters: indexes in decimal, public keys in hex
 const (
-	QueryDataset         QueryType = "QueryDataset"
-	QueryData            QueryType = "QueryData"
-	QueryVersion         QueryType = "QueryVersion"
-	QueryDescription     QueryType = "QueryDescription"
-	QueryValidation      QueryType = "QueryValidation"
-	QueryPayload         QueryType = "QueryPayload"
-	QueryAcceptedPayload QueryType = "QueryAcceptedPayload"
-	QueryBalance         QueryType = "QueryBalance"
-	QueryStake           QueryType = "QueryStake"
+	PathDataset         = "/dataset"
+	PathData            = "/dataset/data/{index}"
+	PathDescription     = "/dataset/data/{index}/description"
+	PathVersion         = "/dataset/data/{index}/versions/{version}"
+	PathValidation      = "/dataset/data/{index}/versions/{version}/validation"
+	PathPayload         = "/dataset/data/{index}/versions/{version}/payload"
+	PathAcceptedPayload = "/dataset/data/{index}/versions/{version}/accepted"
+	PathRejectedPayload = "/dataset/data/{index}/versions/{version}/rejected"
+	PathReveal          = "/dataset/data/{index}/versions/{version}/reveal"
+	PathBalances        = "/balance"
+	PathBalance         = "/balance/{pubkey}"
+	PathStakes          = "/stake"
+	PathStake           = "/stake/{validator}"
+	PathSchemas         = "/schemas"
+	PathSchema          = "/schemas/{id}"
+	PathRoleIndex       = "/index/roles/{role}/{pubkey}"
+	PathStateIndex      = "/index/states/{state}"
+	PathRoleStateIndex  = "/index/roles/{role}/{pubkey}/{state}"
 )
 
-type Query struct {
-	QrType       QueryType
-	DataIndex    int
-	VersionIndex int
+// Index roles, the data and versions a public key takes part in
+const (
+	RoleRequirer  = "requirer"  // data required
+	RoleValidator = "validator" // data listing the key as validator, versions validated
+	RoleProvider  = "provider"  // versions provided
+	RoleAcceptor  = "acceptor"  // data listing the key as acceptor, versions accepted or rejected
+)
+
+// Index states, the data and versions waiting for something, closed data aside
+const (
+	StateAwaitingValidation = "awaiting_validation" // open data taking new versions
+	StateAwaitingPayload    = "awaiting_payload"    // validated versions of open data
+	StateAwaitingAcceptance = "awaiting_acceptance" // provided versions of open data, neither accepted nor rejected
+	StateClosed             = "closed"              // closed or expired data
+)
+
+// Pagination of every list query, with path parameters "?offset=0&limit=100"
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Data or version of an index, Version is -1 for the data itself
+type IndexEntry struct {
+	Data    int
+	Version int
+}
+
+/*
+Page of a list query, Total is the number of items of the whole list. Lists are in a fixed order:
+data by index, accounts, stakes and schemas by key, index entries as in the dataset.
+Items are decoded into the slice Items points to, e.g. Page{Items: &[]modules.Data{}}.
+*/
+type Page struct {
+	Total  int
+	Offset int
+	Limit  int
+	Items  interface{}
+}
+
+// Account of a page of balances, User is the hex encoded public key
+type AccountEntry struct {
+	User string
+	modules.Account
+}
+
+// Stake of a page of stakes, Validator is the hex encoded public key
+type StakeEntry struct {
+	Validator string
+	Stake     int64
+}
+
+// Fills the parameters of a query path pattern in order
+func Path(pattern string, params ...string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if len(params) > 0 && strings.HasPrefix(segment, "{") {
+			segments[i] = params[0]
+			params = params[1:]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Adds pagination to a query path
+func Paged(path string, offset, limit int) string {
+	return path + "?offset=" + strconv.Itoa(offset) + "&limit=" + strconv.Itoa(limit)
 }
@@ -1,11 +1,11 @@
 package modules
 
 import (
-	"crypto/sha256"
 	"dbc-node/crypto"
 	"encoding/hex"
-	"errors"
+	tendermint "github.com/tendermint/tendermint/abci/types"
 	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/libs/kv"
 	"github.com/tendermint/tendermint/types"
 	"strconv"
 )
@@ -24,20 +24,32 @@ func ToSats(dbcc int64) int64 { return dbcc * DbccSats }
 // ------------------------------------------------------------------------------------------------------------------- //
 // BALANCE
 
+// Params are the parameters of the chain, set at genesis
+type Params struct {
+	TxFee *int64 `json:"tx_fee,omitempty"` // sats charged for every transaction, TxFee if unset, can be zero
+}
+
 type Balance struct {
+	ChainID    string // every signature is bound to it
+	Params     Params
 	Users      map[string]int64
+	Nonces     map[string]int64 // next expected nonce of each user
 	Validators map[string]int64
-	ValChanges map[string]int64
-	ValAddr    map[[20]byte][32]byte
-	Transfers  []*Transfer
-	Stakes     []*Stake
+	ValChanges map[string]int64      `json:"-"` // changes in the current block only
+	ValAddr    map[[20]byte][32]byte `json:"-"` // rebuilt from Validators
+	Transfers  []*Transfer           `json:"-"` // history since the node started, not persisted
+	Stakes     []*Stake              `json:"-"` // history since the node started, not persisted
 	Rewards    []Reward
-	Fees       []*Fee
+	Fees       []*Fee             `json:"-"` // history since the node started, not persisted
+	events     []tendermint.Event // emitted since the last call to Events
 }
 
 func NewBalance(oldBalance *Balance) *Balance {
 	balance := &Balance{
+		ChainID:    oldBalance.ChainID,
+		Params:     oldBalance.Params,
 		Users:      make(map[string]int64),
+		Nonces:     make(map[string]int64),
 		Validators: make(map[string]int64),
 		ValChanges: make(map[string]int64),
 		ValAddr:    make(map[[20]byte][32]byte),
@@ -45,6 +57,9 @@ func NewBalance(oldBalance *Balance) *Balance {
 	for user, value := range oldBalance.Users {
 		balance.Users[user] = value
 	}
+	for user, nonce := range oldBalance.Nonces {
+		balance.Nonces[user] = nonce
+	}
 	for validator, value := range oldBalance.Validators {
 		balance.Validators[validator] = value
 	}
@@ -63,8 +78,9 @@ func NewBalance(oldBalance *Balance) *Balance {
 			Info:  oldReward.Info,
 			State: oldReward.State,
 		}
-		for _, confirm := range oldReward.Confirms {
-			reward.Confirms = append(reward.Confirms, confirm)
+		for _, oldConfirm := range oldReward.Confirms {
+			confirm := *oldConfirm // the state of a confirm can change
+			reward.Confirms = append(reward.Confirms, &confirm)
 		}
 		balance.Rewards = append(balance.Rewards, reward)
 	}
@@ -74,57 +90,34 @@ func NewBalance(oldBalance *Balance) *Balance {
 	return balance
 }
 
-func (balance *Balance) Hash() []byte {
-	var sum []byte
-	if balance == nil {
-		return sum
-	}
-	for _, transfer := range balance.Transfers {
-		sum = append(sum, transfer.Hash()...)
-	}
-	for _, stake := range balance.Stakes {
-		sum = append(sum, stake.Hash()...)
-	}
-	for _, reward := range balance.Rewards {
-		sum = append(sum, reward.Hash()...)
-	}
-	for _, fee := range balance.Fees {
-		sum = append(sum, fee.Hash()...)
-	}
-	hash := sha256.Sum256(sum)
-	return hash[:]
-}
-
 func (balance *Balance) AddTransfer(transfer *Transfer) error {
-	if err := transfer.check(); err != nil {
+	if err := transfer.Verify(balance.ChainID); err != nil {
 		return err
 	}
-	if !transfer.isSigned() {
-		return errors.New("invalid transfer signature")
-	}
 	if !balance.hasBalance(transfer.Sender, transfer.Amount) {
-		return errors.New("insufficient balance")
+		return ErrInsufficientBalance
 	}
 	balance.Transfers = append(balance.Transfers, transfer)
 	sender := hex.EncodeToString(transfer.Sender)
 	balance.Users[sender] -= transfer.Amount
 	receiver := hex.EncodeToString(transfer.Receiver)
 	balance.Users[receiver] += transfer.Amount
+	balance.emit(EventTransfer,
+		keyAttribute(AttributeSender, transfer.Sender),
+		keyAttribute(AttributeReceiver, transfer.Receiver),
+		intAttribute(AttributeAmount, transfer.Amount))
 	return nil
 }
 
 func (balance *Balance) AddStake(stake *Stake) error {
-	if err := stake.check(); err != nil {
+	if err := stake.Verify(balance.ChainID); err != nil {
 		return err
 	}
-	if isSigned := stake.isSigned(); !isSigned {
-		return errors.New("invalid stake signature")
-	}
 	if stake.Amount >= 0 && !balance.hasBalance(stake.User, stake.Amount) {
-		return errors.New("insufficient balance")
+		return ErrInsufficientBalance
 	}
 	if stake.Amount < 0 && !balance.hasStake(stake.Validator, -stake.Amount) {
-		return errors.New("insufficient stake")
+		return ErrInsufficientStake
 	}
 	balance.Stakes = append(balance.Stakes, stake)
 	user := hex.EncodeToString(stake.User)
@@ -133,12 +126,19 @@ func (balance *Balance) AddStake(stake *Stake) error {
 	balance.Validators[validator] += stake.Amount
 	balance.ValChanges[validator] += stake.Amount
 	balance.registerValAddr(stake.Validator)
+	balance.emit(EventStake,
+		keyAttribute(AttributeUser, stake.User),
+		keyAttribute(AttributeValidator, stake.Validator),
+		intAttribute(AttributeAmount, stake.Amount))
 	return nil
 }
 
 func (balance *Balance) AddReward(reward Reward) (error, int) {
+	if err := reward.Info.checkAmounts(); err != nil {
+		return err, 0
+	}
 	if !balance.hasBalance(reward.Info.Requirer, reward.totalAmount()) {
-		return errors.New("insufficient balance"), 0
+		return ErrInsufficientBalance, 0
 	}
 	balance.Rewards = append(balance.Rewards, reward)
 	requirer := hex.EncodeToString(reward.Info.Requirer)
@@ -146,45 +146,222 @@ func (balance *Balance) AddReward(reward Reward) (error, int) {
 	return nil, len(balance.Rewards) - 1
 }
 
+// Pays the reward to validator, provider and acceptor
 func (balance *Balance) ConfirmReward(confirm *RewardConfirm, index int) error {
-	reward := &balance.Rewards[index]
+	reward, err := balance.reward(index)
+	if err != nil {
+		return err
+	}
 	if !reward.inRange() {
-		return errors.New("reached max confirms limit or reward is closed")
+		return ErrRewardClosed
+	}
+	confirm.State = RewardPaid
+	reward.Confirms = append(reward.Confirms, confirm)
+	balance.pay(reward, confirm, index)
+	return nil
+}
+
+// Confirms the reward but holds it in escrow until it's released at releaseHeight, or refunded after a dispute
+func (balance *Balance) HoldReward(confirm *RewardConfirm, index int, releaseHeight int64) (error, int) {
+	reward, err := balance.reward(index)
+	if err != nil {
+		return err, 0
+	}
+	if !reward.inRange() {
+		return ErrRewardClosed, 0
+	}
+	confirm.State = RewardPending
+	reward.Confirms = append(reward.Confirms, confirm)
+	confirmIndex := len(reward.Confirms) - 1
+	balance.emit(EventHoldReward,
+		intAttribute(AttributeRewardIndex, int64(index)),
+		intAttribute(AttributeConfirmIndex, int64(confirmIndex)),
+		intAttribute(AttributeReleaseHeight, releaseHeight),
+		keyAttribute(AttributeProvider, confirm.Provider))
+	return nil, confirmIndex
+}
+
+// Pays a held reward, pending or disputed
+func (balance *Balance) ReleaseReward(index, confirmIndex int) error {
+	reward, confirm, err := balance.confirm(index, confirmIndex)
+	if err != nil {
+		return err
+	}
+	if confirm.State != RewardPending && confirm.State != RewardDisputed {
+		return ErrRewardState.Wrap("not held")
+	}
+	confirm.State = RewardPaid
+	balance.pay(reward, confirm, index)
+	return nil
+}
+
+// Stops a pending reward from being released until the dispute is resolved
+func (balance *Balance) DisputeReward(index, confirmIndex int) error {
+	_, confirm, err := balance.confirm(index, confirmIndex)
+	if err != nil {
+		return err
 	}
-	balance.Rewards[index].Confirms = append(balance.Rewards[index].Confirms, confirm)
-	validator := hex.EncodeToString(reward.Info.Validator)
+	if confirm.State != RewardPending {
+		return ErrRewardState.Wrap("not pending")
+	}
+	confirm.State = RewardDisputed
+	return nil
+}
+
+// Cancels a disputed reward, its escrow is left to another confirm or returned to the requirer if the reward is closed
+func (balance *Balance) RefundReward(index, confirmIndex int) error {
+	reward, confirm, err := balance.confirm(index, confirmIndex)
+	if err != nil {
+		return err
+	}
+	if confirm.State != RewardDisputed {
+		return ErrRewardState.Wrap("not disputed")
+	}
+	confirm.State = RewardRefunded
+	var refund int64
+	if reward.State == RewardClosed {
+		refund = reward.confirmAmount()
+		requirer := hex.EncodeToString(reward.Info.Requirer)
+		balance.Users[requirer] += refund
+	}
+	balance.emit(EventRefundReward,
+		intAttribute(AttributeRewardIndex, int64(index)),
+		intAttribute(AttributeConfirmIndex, int64(confirmIndex)),
+		keyAttribute(AttributeRequirer, reward.Info.Requirer),
+		intAttribute(AttributeRefund, refund))
+	return nil
+}
+
+// The acceptor amount is split evenly between the acceptors of the confirm, the remainder goes to the first one
+func (balance *Balance) pay(reward *Reward, confirm *RewardConfirm, index int) {
+	validator := hex.EncodeToString(confirm.Validator)
 	balance.Users[validator] += reward.Info.ValidatorAmount
 	provider := hex.EncodeToString(confirm.Provider)
 	balance.Users[provider] += reward.Info.ProviderAmount
-	acceptor := hex.EncodeToString(reward.Info.Acceptor)
-	balance.Users[acceptor] += reward.Info.AcceptorAmount
-	return nil
+	acceptors := int64(len(confirm.Acceptors))
+	for i, acceptor := range confirm.Acceptors {
+		share := reward.Info.AcceptorAmount / acceptors
+		if i == 0 {
+			share += reward.Info.AcceptorAmount % acceptors
+		}
+		balance.Users[hex.EncodeToString(acceptor)] += share
+	}
+	attributes := []kv.Pair{
+		intAttribute(AttributeRewardIndex, int64(index)),
+		keyAttribute(AttributeRequirer, reward.Info.Requirer),
+		keyAttribute(AttributeValidator, confirm.Validator),
+		keyAttribute(AttributeProvider, confirm.Provider),
+		intAttribute(AttributeValidatorAmount, reward.Info.ValidatorAmount),
+		intAttribute(AttributeProviderAmount, reward.Info.ProviderAmount),
+		intAttribute(AttributeAcceptorAmount, reward.Info.AcceptorAmount),
+	}
+	attributes = append(attributes, keysAttribute(AttributeAcceptor, confirm.Acceptors)...)
+	balance.emit(EventConfirmReward, attributes...)
 }
 
 func (balance *Balance) CloseReward(index int) error {
-	reward := &balance.Rewards[index]
+	reward, err := balance.reward(index)
+	if err != nil {
+		return err
+	}
 	if reward.State == RewardClosed {
-		return errors.New("reward closed")
+		return ErrRewardClosed
 	}
 	requirer := hex.EncodeToString(reward.Info.Requirer)
-	balance.Users[requirer] += reward.onCloseReturn()
+	refund := reward.onCloseReturn()
+	balance.Users[requirer] += refund
 	reward.State = RewardClosed
+	balance.emit(EventCloseReward,
+		intAttribute(AttributeRewardIndex, int64(index)),
+		keyAttribute(AttributeRequirer, reward.Info.Requirer),
+		intAttribute(AttributeRefund, refund))
 	return nil
 }
 
+/*
+Charges the fee of a transaction, the fee nonce must be the next expected nonce of the user.
+A validator, signing its unstakes with its ed25519 key, has no account: it pays from its stake.
+*/
 func (balance *Balance) AddFee(fee *Fee) error {
-	if !balance.hasBalance(fee.User, TxFee) {
-		return errors.New("insufficient balance")
+	if expected := balance.NextNonce(fee.User); fee.Nonce != expected {
+		return ErrInvalidNonce.Wrap("expected " + strconv.FormatInt(expected, 10))
 	}
-	balance.Fees = append(balance.Fees, fee)
+	txFee := balance.Params.txFee()
 	user := hex.EncodeToString(fee.User)
-	balance.Users[user] -= TxFee
+	if crypto.CheckEDPubKey(fee.User) == nil {
+		if !balance.hasStake(fee.User, txFee) {
+			return ErrInsufficientStake.Wrap("can't pay fee")
+		}
+		balance.Validators[user] -= txFee
+		balance.ValChanges[user] -= txFee
+	} else if balance.hasBalance(fee.User, txFee) {
+		balance.Users[user] -= txFee
+	} else {
+		return ErrInsufficientBalance.Wrap("can't pay fee")
+	}
+	balance.Fees = append(balance.Fees, fee)
+	balance.Nonces[user]++
 	validator := hex.EncodeToString(balance.searchValAddr(fee.ValAddr))
-	balance.Validators[validator] += TxFee
-	balance.ValChanges[validator] += TxFee
+	balance.Validators[validator] += txFee
+	balance.ValChanges[validator] += txFee
+	return nil
+}
+
+func (params Params) txFee() int64 {
+	if params.TxFee == nil {
+		return TxFee
+	}
+	return *params.TxFee
+}
+
+// Checks the parameters are usable
+func (params Params) Check() error {
+	if params.TxFee != nil && *params.TxFee < 0 {
+		return ErrInvalidAmount.Wrap("negative transaction fee")
+	}
 	return nil
 }
 
+func (balance *Balance) NextNonce(user []byte) int64 {
+	return balance.Nonces[hex.EncodeToString(user)]
+}
+
+// Account is the state of a single user, identified by the hex encoding of the public key
+type Account struct {
+	Balance int64
+	Nonce   int64
+}
+
+func (balance *Balance) Account(user string) Account {
+	return Account{
+		Balance: balance.Users[user],
+		Nonce:   balance.Nonces[user],
+	}
+}
+
+func (balance *Balance) reward(index int) (*Reward, error) {
+	if index < 0 || index >= len(balance.Rewards) {
+		return nil, ErrUnknownReward.Wrap("index " + strconv.Itoa(index))
+	}
+	return &balance.Rewards[index], nil
+}
+
+func (balance *Balance) confirm(index, confirmIndex int) (*Reward, *RewardConfirm, error) {
+	reward, err := balance.reward(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if confirmIndex < 0 || confirmIndex >= len(reward.Confirms) {
+		return nil, nil, ErrUnknownReward.Wrap("confirm " + strconv.Itoa(confirmIndex))
+	}
+	return reward, reward.Confirms[confirmIndex], nil
+}
+
+func (balance *Balance) pending(index, confirmIndex int) bool {
+	_, confirm, err := balance.confirm(index, confirmIndex)
+	return err == nil && confirm.State == RewardPending
+}
+
 func (balance *Balance) hasBalance(user []byte, amount int64) bool {
 	return balance.Users[hex.EncodeToString(user)] >= amount
 }
@@ -216,35 +393,48 @@ type Transfer struct {
 	Receiver  []byte
 	Amount    int64
 	Time      int64
+	Nonce     int64
 	Signature []byte
 }
 
-func (transfer *Transfer) Hash() []byte {
-	sum := append(transfer.Sender, transfer.Receiver...)
-	sum = append(sum, []byte(strconv.FormatInt(transfer.Amount, 10))...)
-	sum = append(sum, []byte(strconv.FormatInt(transfer.Time, 10))...)
-	sum = append(sum, transfer.Signature...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
+func (transfer *Transfer) Verify(chainID string) error {
+	if err := transfer.check(); err != nil {
+		return err
+	}
+	if !transfer.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("transfer")
+	}
+	return nil
+}
+
+func (transfer *Transfer) Payer() []byte {
+	return transfer.Sender
+}
+
+func (transfer *Transfer) GetNonce() int64 {
+	return transfer.Nonce
 }
 
 func (transfer *Transfer) check() error {
-	if err := crypto.CheckPubKey(transfer.Sender); err != nil {
+	if err := checkPubKey("sender", transfer.Sender); err != nil {
 		return err
-	} else if err := crypto.CheckPubKey(transfer.Receiver); err != nil {
+	} else if err := checkPubKey("receiver", transfer.Receiver); err != nil {
 		return err
 	} else if transfer.Amount < 0 {
-		return errors.New("negative transfer amount")
+		return ErrInvalidAmount.Wrap("negative transfer amount")
 	} else {
 		return nil
 	}
 }
 
-func (transfer *Transfer) isSigned() bool {
-	id := append(transfer.Sender, transfer.Receiver...)
-	id = append(id, []byte(strconv.FormatInt(transfer.Amount, 10))...)
-	id = append(id, []byte(strconv.FormatInt(transfer.Time, 10))...)
-	return crypto.Verify(transfer.Sender, id, transfer.Signature)
+func (transfer *Transfer) SignBytes(chainID string) []byte {
+	unsigned := *transfer
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeTransfer, unsigned)
+}
+
+func (transfer *Transfer) isSigned(chainID string) bool {
+	return crypto.Verify(transfer.Sender, transfer.SignBytes(chainID), transfer.Signature)
 }
 
 // ------------------------------------------------------------------------------------------------------------------- //
@@ -255,36 +445,71 @@ type Stake struct {
 	Validator []byte
 	Amount    int64
 	Time      int64
+	Nonce     int64
 	Signature []byte
 }
 
-func (stake *Stake) Hash() []byte {
-	sum := append(stake.User, stake.Validator...)
-	sum = append(sum, []byte(strconv.FormatInt(stake.Amount, 10))...)
-	sum = append(sum, []byte(strconv.FormatInt(stake.Time, 10))...)
-	sum = append(sum, stake.Signature...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
+func (stake *Stake) Verify(chainID string) error {
+	if err := stake.check(); err != nil {
+		return err
+	}
+	if !stake.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("stake")
+	}
+	return nil
+}
+
+// The user signing a stake pays its fee, the validator signing an unstake pays its own
+func (stake *Stake) Payer() []byte {
+	if stake.Amount < 0 {
+		return stake.Validator
+	}
+	return stake.User
+}
+
+func (stake *Stake) GetNonce() int64 {
+	return stake.Nonce
 }
 
 func (stake *Stake) check() error {
-	if err := crypto.CheckPubKey(stake.User); err != nil {
+	if err := checkPubKey("user", stake.User); err != nil {
 		return err
-	} else if err := crypto.CheckEDPubKey(stake.Validator); err != nil {
+	} else if err := checkEDPubKey("validator", stake.Validator); err != nil {
 		return err
 	} else {
 		return nil
 	}
 }
 
-func (stake *Stake) isSigned() bool {
-	id := append(stake.User, stake.Validator...)
-	id = append(id, []byte(strconv.FormatInt(stake.Amount, 10))...)
-	id = append(id, []byte(strconv.FormatInt(stake.Time, 10))...)
+func (stake *Stake) SignBytes(chainID string) []byte {
+	unsigned := *stake
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeStake, unsigned)
+}
+
+// Sign bytes of the stake of a gentx, signed by its user
+func (stake *Stake) GenTxSignBytes(chainID string) []byte {
+	unsigned := *stake
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeGenTx, unsigned)
+}
+
+// Checks the stake of a gentx, its signature of the gentx sign bytes by its user
+func (stake *Stake) VerifyGenTx(chainID string) error {
+	if err := stake.check(); err != nil {
+		return err
+	}
+	if !crypto.Verify(stake.User, stake.GenTxSignBytes(chainID), stake.Signature) {
+		return ErrInvalidSignature.Wrap("gentx stake")
+	}
+	return nil
+}
+
+func (stake *Stake) isSigned(chainID string) bool {
 	if stake.Amount >= 0 {
-		return crypto.Verify(stake.User, id, stake.Signature)
+		return crypto.Verify(stake.User, stake.SignBytes(chainID), stake.Signature)
 	} else {
-		return crypto.VerifyED(stake.Validator, id, stake.Signature)
+		return crypto.VerifyED(stake.Validator, stake.SignBytes(chainID), stake.Signature)
 	}
 }
 
@@ -299,48 +524,78 @@ type Reward struct {
 
 type RewardInfo struct {
 	Requirer        []byte
-	Validator       []byte
-	Acceptor        []byte
+	Validators      [][]byte
+	Acceptors       [][]byte
 	ValidatorAmount int64
 	ProviderAmount  int64
 	AcceptorAmount  int64
 	MaxConfirms     int64
 }
 
+// Checks the amounts aren't negative and the escrow of every confirm is within the supply, so totalAmount can't overflow
+func (info *RewardInfo) checkAmounts() error {
+	var confirm int64
+	for _, amount := range []int64{info.ValidatorAmount, info.ProviderAmount, info.AcceptorAmount} {
+		if amount < 0 {
+			return ErrInvalidAmount.Wrap("negative reward amount")
+		}
+		if amount > SatsSupply-confirm {
+			return ErrInvalidAmount.Wrap("reward amounts over the supply")
+		}
+		confirm += amount
+	}
+	if info.MaxConfirms < 0 {
+		return ErrInvalidAmount.Wrap("negative max confirms")
+	}
+	if info.MaxConfirms > 0 && confirm > SatsSupply/info.MaxConfirms {
+		return ErrInvalidAmount.Wrap("escrow of the reward over the supply")
+	}
+	return nil
+}
+
+// The participants to a confirmed version, paid by the reward
 type RewardConfirm struct {
-	Provider []byte
+	Provider  []byte
+	Validator []byte
+	Acceptors [][]byte
+	State     RewardState
 }
 
+// A reward is open or closed, each of its confirms is pending, disputed, paid or refunded
 type RewardState int8
 
 const RewardOpen RewardState = 0
 const RewardClosed RewardState = 1
+const RewardPending RewardState = 2  // held in escrow during the challenge window
+const RewardDisputed RewardState = 3 // held in escrow until the arbiter resolves the dispute
+const RewardPaid RewardState = 4
+const RewardRefunded RewardState = 5 // doesn't count against max confirms
 
-func (reward *Reward) Hash() []byte {
-	sum := append(reward.Info.Requirer, reward.Info.Validator...)
-	sum = append(sum, reward.Info.Acceptor...)
-	sum = append(sum, []byte(strconv.FormatInt(reward.Info.ValidatorAmount, 10))...)
-	sum = append(sum, []byte(strconv.FormatInt(reward.Info.ProviderAmount, 10))...)
-	sum = append(sum, []byte(strconv.FormatInt(reward.Info.AcceptorAmount, 10))...)
-	sum = append(sum, []byte(strconv.FormatInt(reward.Info.MaxConfirms, 10))...)
-	for _, confirm := range reward.Confirms {
-		sum = append(sum, confirm.Provider...)
-	}
-	hash := sha256.Sum256(sum)
-	return hash[:]
+func (reward *Reward) confirmAmount() int64 {
+	return reward.Info.ValidatorAmount + reward.Info.ProviderAmount + reward.Info.AcceptorAmount
 }
 
 func (reward *Reward) totalAmount() int64 {
-	return (reward.Info.ValidatorAmount + reward.Info.ProviderAmount + reward.Info.AcceptorAmount) * reward.Info.MaxConfirms
+	return reward.confirmAmount() * reward.Info.MaxConfirms
+}
+
+func (reward *Reward) activeConfirms() int64 {
+	var active int64
+	for _, confirm := range reward.Confirms {
+		if confirm.State != RewardRefunded {
+			active++
+		}
+	}
+	return active
 }
 
 func (reward *Reward) inRange() bool {
-	return int64(len(reward.Confirms)) < reward.Info.MaxConfirms && reward.State == RewardOpen
+	return reward.activeConfirms() < reward.Info.MaxConfirms && reward.State == RewardOpen
 }
 
 func (reward *Reward) onCloseReturn() int64 {
-	paid := (reward.Info.ValidatorAmount + reward.Info.ProviderAmount + reward.Info.AcceptorAmount) * reward.Info.MaxConfirms
-	due := (reward.Info.ValidatorAmount + reward.Info.ProviderAmount + reward.Info.AcceptorAmount) * int64(len(reward.Confirms))
+	paid := reward.totalAmount()
+	due := reward.confirmAmount() * reward.activeConfirms()
 	return paid - due
 }
 
@@ -351,11 +606,5 @@ type Fee struct {
 	User    []byte
 	ValAddr []byte
 	TxHash  []byte
-}
-
-func (fee *Fee) Hash() []byte {
-	sum := append(fee.User, fee.ValAddr...)
-	sum = append(sum, fee.TxHash...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
+	Nonce   int64
 }
@@ -13,7 +13,8 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"dbc-node/crypto"
-	"errors"
+	"github.com/tendermint/tendermint/libs/kv"
+	"strconv"
 )
 
 type Empty interface {
@@ -25,21 +26,38 @@ type Empty interface {
 
 type Dataset struct {
 	DataList []Data
+	Schemas  map[string]*Schema // by ID, never changed once registered
 	balance  *Balance
+	height   int64 // current block height
+	time     int64 // current block time, unix seconds
 }
 
 func NewDataset(old *Dataset, balance *Balance) *Dataset { // called every new block
 	dataset := &Dataset{balance: balance}
+	if len(old.Schemas) > 0 {
+		dataset.Schemas = make(map[string]*Schema, len(old.Schemas))
+		for id, schema := range old.Schemas {
+			dataset.Schemas[id] = schema
+		}
+	}
 	for _, oldData := range old.DataList {
 		data := Data{
 			Description: oldData.Description,
 			Reward:      oldData.Reward,
+			Closed:      oldData.Closed,
 		}
 		for _, oldVersion := range oldData.VersionList {
 			version := Version{
+				RejectedPayload: oldVersion.RejectedPayload,
 				AcceptedPayload: oldVersion.AcceptedPayload,
+				Acceptances:     append([]*AcceptedPayload(nil), oldVersion.Acceptances...),
 				Payload:         oldVersion.Payload,
 				Validation:      oldVersion.Validation,
+				Confirm:         oldVersion.Confirm,
+				ReleaseHeight:   oldVersion.ReleaseHeight,
+				Dispute:         oldVersion.Dispute,
+				Resolution:      oldVersion.Resolution,
+				Reveal:          oldVersion.Reveal,
 			}
 			data.VersionList = append(data.VersionList, version)
 		}
@@ -48,24 +66,37 @@ func NewDataset(old *Dataset, balance *Balance) *Dataset { // called every new b
 	return dataset
 }
 
-func (dataset *Dataset) Hash() []byte {
-	var sum []byte
-	if dataset == nil {
-		return sum
-	}
-	for i := range dataset.DataList {
-		sum = append(sum, dataset.DataList[i].Hash()...)
+// Sets the current block, closes the data expired at it and releases the rewards at the end of their
+// challenge window, called at the beginning of every block
+func (dataset *Dataset) BeginBlock(height, time int64) {
+	dataset.height = height
+	dataset.time = time
+	for dataIndex := range dataset.DataList {
+		data := &dataset.DataList[dataIndex]
+		if !data.Closed && data.expired(height, time) && dataset.balance.CloseReward(data.Reward) == nil {
+			data.Closed = true
+			dataset.balance.emit(EventExpireData,
+				intAttribute(AttributeDataIndex, int64(dataIndex)),
+				keyAttribute(AttributeRequirer, data.Description.Requirer))
+		}
+		for versionIndex := range data.VersionList {
+			version := &data.VersionList[versionIndex]
+			if version.ReleaseHeight > 0 && height >= version.ReleaseHeight && dataset.balance.pending(data.Reward, version.Confirm) {
+				_ = dataset.balance.ReleaseReward(data.Reward, version.Confirm)
+			}
+		}
 	}
-	hash := sha256.Sum256(sum)
-	return hash[:]
 }
 
 func (dataset *Dataset) AddData(description *Description) error { // called at requireTx
-	if err := description.check(); err != nil {
+	if err := description.Verify(dataset.balance.ChainID); err != nil {
 		return err
 	}
-	if !description.isSigned() {
-		return errors.New("invalid description signature")
+	if description.expired(dataset.height, dataset.time) {
+		return ErrInvalidExpiry.Wrap("already passed")
+	}
+	if err := dataset.checkSchemas(description); err != nil {
+		return err
 	}
 	err, index := dataset.balance.AddReward(description.reward())
 	if err != nil {
@@ -73,172 +104,491 @@ func (dataset *Dataset) AddData(description *Description) error { // called at r
 	}
 	data := Data{Description: description, Reward: index}
 	dataset.DataList = append(dataset.DataList, data)
-	dataset.Hash()
+	attributes := []kv.Pair{
+		intAttribute(AttributeDataIndex, int64(len(dataset.DataList)-1)),
+		keyAttribute(AttributeRequirer, description.Requirer),
+		intAttribute(AttributeValidatorAmount, description.ValidatorAmount),
+		intAttribute(AttributeProviderAmount, description.ProviderAmount),
+		intAttribute(AttributeAcceptorAmount, description.AcceptorAmount),
+	}
+	attributes = append(attributes, keysAttribute(AttributeValidator, description.Validators)...)
+	attributes = append(attributes, keysAttribute(AttributeAcceptor, description.Acceptors)...)
+	dataset.balance.emit(EventAddData, attributes...)
 	return nil
 }
 
 func (dataset *Dataset) AddValidation(validation *Validation, dataIndex int) error { // called at validateTx
-	if err := validation.check(); err != nil {
+	if err := validation.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkDataIndex(validation.DataIndex, dataIndex); err != nil {
 		return err
 	}
-	if !validation.isSigned() {
-		return errors.New("invalid validation signature")
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if err := data.open(dataset.height, dataset.time); err != nil {
+		return err
 	}
-	data := &dataset.DataList[dataIndex]
 	if !data.isValidator(validation) {
-		return errors.New("validator not approved")
+		return ErrNotApproved.Wrap("validator")
+	}
+	verifier, err := verifier(data.Description.ProofScheme)
+	if err != nil {
+		return err
+	}
+	if err := verifier.CheckInfo(validation.Info); err != nil {
+		return err
 	}
 	if !data.inRange() {
-		return errors.New("reached max versions limit")
+		return ErrMaxVersions
 	}
-	version := Version{Validation: validation, Payload: &Payload{}, AcceptedPayload: &AcceptedPayload{}}
+	version := Version{Validation: validation, Payload: &Payload{}, AcceptedPayload: &AcceptedPayload{}, RejectedPayload: &RejectedPayload{}}
 	data.VersionList = append(data.VersionList, version)
-	dataset.Hash()
+	dataset.balance.emit(EventAddValidation,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(len(data.VersionList)-1)),
+		keyAttribute(AttributeRequirer, data.Description.Requirer),
+		keyAttribute(AttributeValidator, validation.ValidatorAddr))
 	return nil
 }
 
 func (dataset *Dataset) AddPayload(payload *Payload, dataIndex int, versionIndex int) error { //called at provideTx
-	if err := payload.check(); err != nil {
+	if err := payload.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkIndexes(payload.DataIndex, payload.VersionIndex, dataIndex, versionIndex); err != nil {
 		return err
 	}
-	if !payload.isSigned() {
-		return errors.New("invalid payload signature")
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if err := data.open(dataset.height, dataset.time); err != nil {
+		return err
+	}
+	version, err := data.version(versionIndex)
+	if err != nil {
+		return err
+	}
+	if version.rejected() {
+		return ErrPayloadRejected
+	}
+	verifier, err := verifier(data.Description.ProofScheme)
+	if err != nil {
+		return err
 	}
-	version := &dataset.DataList[dataIndex].VersionList[versionIndex]
-	if !version.prove(payload) {
-		return errors.New("invalid payload proof")
+	if !version.prove(verifier, payload) {
+		return ErrInvalidProof
 	}
 	if !version.Payload.IsEmpty() {
-		return errors.New("payload already exists")
+		return ErrPayloadExists
 	}
 	version.Payload = payload
-	dataset.Hash()
+	dataset.balance.emit(EventAddPayload,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(versionIndex)),
+		keyAttribute(AttributeRequirer, data.Description.Requirer),
+		keyAttribute(AttributeProvider, payload.ProviderAddr))
 	return nil
 }
 
 func (dataset *Dataset) AcceptPayload(acceptedPayload *AcceptedPayload, dataIndex int, versionIndex int) error { //called at acceptTx
-	if err := acceptedPayload.check(); err != nil {
+	if err := acceptedPayload.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkIndexes(acceptedPayload.DataIndex, acceptedPayload.VersionIndex, dataIndex, versionIndex); err != nil {
 		return err
 	}
-	if !acceptedPayload.isSigned() {
-		return errors.New("invalid accepted payload signature")
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if err := data.open(dataset.height, dataset.time); err != nil {
+		return err
 	}
-	data := &dataset.DataList[dataIndex]
 	if !data.isAcceptor(acceptedPayload) {
-		return errors.New("acceptor not approved")
+		return ErrNotApproved.Wrap("acceptor")
 	}
-	version := &data.VersionList[versionIndex]
-	if !version.AcceptedPayload.IsEmpty() {
-		return errors.New("accepted payload already exists")
+	version, err := data.version(versionIndex)
+	if err != nil {
+		return err
+	}
+	if version.Payload.IsEmpty() {
+		return ErrMissingPayload
+	}
+	if version.rejected() {
+		return ErrPayloadRejected
+	}
+	if version.accepted() {
+		return ErrAcceptedExists
 	}
-	if err := dataset.balance.ConfirmReward(version.rewardConfirm(), data.Reward); err != nil {
+	if version.hasAccepted(acceptedPayload.AcceptorAddr) {
+		return ErrAcceptedExists.Wrap("by the same acceptor")
+	}
+	if checkCommitment(version.Payload.Commitment) != nil || // payloads older than the commitments
+		bytes.Compare(acceptedPayload.Commitment, version.Payload.Commitment) != 0 {
+		return ErrCommitmentMismatch
+	}
+	if int64(len(version.Acceptances)+1) >= data.Description.threshold() { // the last acceptance needed confirms the reward
+		confirm := version.rewardConfirm(acceptedPayload.AcceptorAddr)
+		if window := data.Description.ChallengeWindow; window > 0 {
+			err, confirmIndex := dataset.balance.HoldReward(confirm, data.Reward, dataset.height+window)
+			if err != nil {
+				return err
+			}
+			version.Confirm = confirmIndex
+			version.ReleaseHeight = dataset.height + window
+		} else if err := dataset.balance.ConfirmReward(confirm, data.Reward); err != nil {
+			return err
+		}
+		version.AcceptedPayload = acceptedPayload
+	}
+	version.Acceptances = append(version.Acceptances, acceptedPayload)
+	dataset.balance.emit(EventAcceptPayload,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(versionIndex)),
+		keyAttribute(AttributeRequirer, data.Description.Requirer),
+		keyAttribute(AttributeProvider, version.Payload.ProviderAddr),
+		keyAttribute(AttributeAcceptor, acceptedPayload.AcceptorAddr),
+		intAttribute(AttributeAcceptances, int64(len(version.Acceptances))))
+	return nil
+}
+
+// Marks the payload of the version as rejected, the version doesn't count against max versions anymore
+func (dataset *Dataset) RejectPayload(rejectedPayload *RejectedPayload, dataIndex int, versionIndex int) error { //called at rejectTx
+	if err := rejectedPayload.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkIndexes(rejectedPayload.DataIndex, rejectedPayload.VersionIndex, dataIndex, versionIndex); err != nil {
+		return err
+	}
+	data, err := dataset.data(dataIndex)
+	if err != nil {
 		return err
 	}
-	version.AcceptedPayload = acceptedPayload
-	dataset.Hash()
+	if err := data.open(dataset.height, dataset.time); err != nil {
+		return err
+	}
+	if !data.isRejector(rejectedPayload) {
+		return ErrNotApproved.Wrap("acceptor")
+	}
+	version, err := data.version(versionIndex)
+	if err != nil {
+		return err
+	}
+	if version.Payload.IsEmpty() {
+		return ErrMissingPayload
+	}
+	if version.rejected() {
+		return ErrPayloadRejected
+	}
+	if version.accepted() {
+		return ErrAcceptedExists
+	}
+	version.RejectedPayload = rejectedPayload
+	dataset.balance.emit(EventRejectPayload,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(versionIndex)),
+		keyAttribute(AttributeRequirer, data.Description.Requirer),
+		keyAttribute(AttributeProvider, version.Payload.ProviderAddr),
+		keyAttribute(AttributeAcceptor, rejectedPayload.AcceptorAddr),
+		stringAttribute(AttributeReason, rejectedPayload.Reason))
 	return nil
 }
 
+/*
+Closes the data to new versions and refunds the requirer of the rewards not confirmed yet.
+The data can't be closed while a payload awaits acceptance, its provider would lose the reward:
+the acceptors must accept or reject it first, or the data expire.
+*/
+func (dataset *Dataset) CloseData(closeData *CloseData, dataIndex int) error { // called at closeTx
+	if err := closeData.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkDataIndex(closeData.DataIndex, dataIndex); err != nil {
+		return err
+	}
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if !data.isRequirer(closeData) {
+		return ErrNotApproved.Wrap("requirer")
+	}
+	if err := data.open(dataset.height, dataset.time); err != nil {
+		return err
+	}
+	for i := range data.VersionList {
+		if data.VersionList[i].AwaitingAcceptance() {
+			return ErrAwaitingAcceptance.Wrap("version " + strconv.Itoa(i))
+		}
+	}
+	if err := dataset.balance.CloseReward(data.Reward); err != nil {
+		return err
+	}
+	data.Closed = true
+	dataset.balance.emit(EventCloseData,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		keyAttribute(AttributeRequirer, closeData.Requirer))
+	return nil
+}
+
+// Returns the size of the off-chain content with the given hash, if a payload or an accepted payload references it
+func (dataset *Dataset) ContentSize(hash []byte) (int64, bool) {
+	for i := range dataset.DataList {
+		for j := range dataset.DataList[i].VersionList {
+			version := &dataset.DataList[i].VersionList[j]
+			if version.Payload.references(hash) {
+				return version.Payload.ContentSize, true
+			}
+			for _, acceptance := range version.Acceptances {
+				if acceptance.references(hash) {
+					return acceptance.ContentSize, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func (dataset *Dataset) data(dataIndex int) (*Data, error) {
+	if dataIndex < 0 || dataIndex >= len(dataset.DataList) {
+		return nil, ErrUnknownData.Wrap("index " + strconv.Itoa(dataIndex))
+	}
+	return &dataset.DataList[dataIndex], nil
+}
+
 // ------------------------------------------------------------------------------------------------------------------- //
 // DATA
 
 /*
-	Represent data of some type with a single owner
-	Contains a general description of itself defined at creation by the owner
-	and many versions that can be added later by people decided by the owner
-	Can be hashed by adding hashes of description and every version, and hashing the result
+Represent data of some type with a single owner
+Contains a general description of itself defined at creation by the owner
+and many versions that can be added later by people decided by the owner, until the owner closes it
+Can be hashed by adding hashes of description and every version, and hashing the result
 */
 type Data struct {
 	Description *Description
 	VersionList []Version
 	Reward      int
+	Closed      bool
 }
 
-func (data *Data) Hash() []byte {
-	sum := data.Description.Hash()
-	for i := range data.VersionList {
-		sum = append(sum, data.VersionList[i].Hash()...)
+func (data *Data) version(versionIndex int) (*Version, error) {
+	if versionIndex < 0 || versionIndex >= len(data.VersionList) {
+		return nil, ErrUnknownVersion.Wrap("index " + strconv.Itoa(versionIndex))
 	}
-	hash := sha256.Sum256(sum)
-	return hash[:]
+	return &data.VersionList[versionIndex], nil
 }
 
 func (data *Data) isValidator(validation *Validation) bool {
-	return bytes.Compare(validation.ValidatorAddr, data.Description.Validator) == 0
+	return isListed(validation.ValidatorAddr, data.Description.Validators)
 }
 
 func (data *Data) isAcceptor(acceptedPayload *AcceptedPayload) bool {
-	return bytes.Compare(acceptedPayload.AcceptorAddr, data.Description.Acceptor) == 0
+	return isListed(acceptedPayload.AcceptorAddr, data.Description.Acceptors)
+}
+
+// Returns an error if the data can't be changed anymore, because it expired or was closed
+func (data *Data) open(height, time int64) error {
+	if data.expired(height, time) {
+		return ErrDataExpired
+	}
+	if data.Closed {
+		return ErrDataClosed
+	}
+	return nil
+}
+
+func (data *Data) expired(height, time int64) bool {
+	return data.Description.expired(height, time)
+}
+
+// The acceptors reject payloads, or the requirer if there are none, not anyone
+func (data *Data) isRejector(rejectedPayload *RejectedPayload) bool {
+	if len(data.Description.Acceptors) == 0 {
+		return bytes.Compare(rejectedPayload.AcceptorAddr, data.Description.Requirer) == 0
+	}
+	return isListed(rejectedPayload.AcceptorAddr, data.Description.Acceptors)
+}
+
+// An empty list allows anyone
+func isListed(pubKey []byte, list [][]byte) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, listed := range list {
+		if bytes.Compare(pubKey, listed) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (data *Data) isRequirer(closeData *CloseData) bool {
+	return bytes.Compare(closeData.Requirer, data.Description.Requirer) == 0
+}
+
+// Whether the data can take new versions
+func (data *Data) AwaitingValidation() bool {
+	return !data.Closed && data.inRange()
 }
 
 func (data *Data) inRange() bool {
-	return int64(len(data.VersionList)) < data.Description.MaxVersions
+	var versions int64
+	for i := range data.VersionList {
+		if !data.VersionList[i].rejected() {
+			versions++
+		}
+	}
+	return versions < data.Description.MaxVersions
 }
 
 // ------------------------------------------------------------------------------------------------------------------- //
 // DESCRIPTION
 
-/*	Describes the type of data with DataInfo and the expected data provider with ProviderInfo, both generic, anything will be accepted.
-	Defines data owner with Requirer, must be a valid secp256k1 public key. The Signature must be a valid Signature
-	of (description.ProviderInfo + description.DataInfo) for the given key.
-	Defines a list of trusted validators, each must be a secp256k1 public key, they are expected to
-	define valid data providers conforming to ProviderInfo, they could be a government entity, some other trusted entity,
-	the owner himself or can be left blank if any data from any provider is accepted.
-	Defines an Acceptor, must be a secp256k1 public key, he is responsible for manually checking the data and
-	confirming its conformance to the data requested in DataInfo
-	Contains only arrays of bytes (amounts don't count). Can be hashed by adding hashes of every field and hashing the result. */
+/*
+Describes the type of data with DataInfo and the expected data provider with ProviderInfo, both generic, anything will be accepted.
+Defines data owner with Requirer, must be a valid secp256k1 public key. The Signature must be a valid Signature
+of the description sign bytes for the given key.
+Defines a list of trusted validators, each must be a secp256k1 public key, they are expected to
+define valid data providers conforming to ProviderInfo, they could be a government entity, some other trusted entity,
+the owner himself or can be left blank if any data from any provider is accepted.
+Defines a list of Acceptors, each must be a secp256k1 public key, or can be left blank if anyone can accept.
+A payload is accepted once AcceptorThreshold of them accepted it (at least one, and only one if anyone can accept,
+as a single party could meet any threshold with its own keys), the validator amount then goes to
+the validator of the version and the acceptor amount is split between the acceptors who accepted it.
+Can define an Arbiter, must be a secp256k1 public key, and a ChallengeWindow in blocks: the rewards of an accepted
+payload are held until the end of the window, during which the requirer can dispute the acceptance, the arbiter
+then decides whether the rewards are paid or refunded. With no window the rewards are paid at acceptance.
+Can define an expiry block height and an expiry block time (unix seconds), zero for none: from the first block
+reaching either of them the data is closed and the rewards not confirmed yet are returned to the requirer.
+Defines the ProofScheme of the payload proofs, the legacy hash chain if blank (see ProofVerifier).
+Can reference registered schemas by ID: ProviderSchema, checked against ProviderInfo when the data is added,
+and DataSchema, the shape of the payload plaintext (see Schema).
+Acceptors are responsible for checking the data and confirming its conformance to the data requested in DataInfo
+and to the DataSchema if any, unless the description attaches Rules accepting the payload automatically (see Rule).
+Contains only arrays of bytes (amounts don't count). Can be hashed by adding hashes of every field and hashing the result.
+*/
 type Description struct {
-	ProviderInfo    []byte
-	DataInfo        []byte
-	Validator       []byte
-	Acceptor        []byte
-	Requirer        []byte
-	ValidatorAmount int64
-	ProviderAmount  int64
-	AcceptorAmount  int64
-	MaxVersions     int64
-	Signature       []byte
-}
-
-func (description *Description) Hash() []byte {
-	sum := append(description.ProviderInfo, description.DataInfo...)
-	sum = append(sum, description.Validator...)
-	sum = append(sum, description.Acceptor...)
-	sum = append(sum, description.Requirer...)
-	sum = append(sum, description.Signature...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
+	ProviderInfo      []byte
+	DataInfo          []byte
+	Validators        [][]byte
+	Acceptors         [][]byte
+	Requirer          []byte
+	Arbiter           []byte
+	ProofScheme       string
+	ProviderSchema    string
+	DataSchema        string
+	Rules             []Rule
+	ValidatorAmount   int64
+	ProviderAmount    int64
+	AcceptorAmount    int64
+	AcceptorThreshold int64
+	MaxVersions       int64
+	ChallengeWindow   int64
+	ExpiryHeight      int64
+	ExpiryTime        int64
+	Nonce             int64
+	Signature         []byte
+}
+
+func (description *Description) Verify(chainID string) error {
+	if err := description.check(); err != nil {
+		return err
+	}
+	if !description.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("description")
+	}
+	return nil
+}
+
+func (description *Description) Payer() []byte {
+	return description.Requirer
+}
+
+func (description *Description) GetNonce() int64 {
+	return description.Nonce
 }
 
 func (description Description) check() error {
-	if err := crypto.CheckPubKey(description.Requirer); err != nil {
+	if err := checkPubKey("requirer", description.Requirer); err != nil {
 		return err
-	} else if err := crypto.CheckPubKey(description.Validator); err != nil {
+	} else if err := checkPubKeys("validator", description.Validators); err != nil {
 		return err
-	} else if err := crypto.CheckPubKey(description.Acceptor); err != nil {
-		return nil
+	} else if err := checkPubKeys("acceptor", description.Acceptors); err != nil {
+		return err
+	} else if description.AcceptorThreshold < 0 {
+		return ErrInvalidAmount.Wrap("negative acceptor threshold")
+	} else if len(description.Acceptors) > 0 && description.AcceptorThreshold > int64(len(description.Acceptors)) {
+		return ErrInvalidAmount.Wrap("acceptor threshold greater than acceptors")
+	} else if len(description.Acceptors) == 0 && description.AcceptorThreshold > 1 {
+		return ErrInvalidAmount.Wrap("acceptor threshold without acceptors") // anyone could meet it alone with many keys
 	} else if description.ValidatorAmount < 0 {
-		return errors.New("negative validator amount")
+		return ErrInvalidAmount.Wrap("negative validator amount")
 	} else if description.ProviderAmount < 0 {
-		return errors.New("negative provider amount")
+		return ErrInvalidAmount.Wrap("negative provider amount")
 	} else if description.AcceptorAmount < 0 {
-		return errors.New("negative acceptor amount")
+		return ErrInvalidAmount.Wrap("negative acceptor amount")
+	} else if description.MaxVersions < 0 {
+		return ErrInvalidAmount.Wrap("negative max versions")
+	} else if description.ChallengeWindow < 0 {
+		return ErrInvalidAmount.Wrap("negative challenge window")
+	} else if err := description.reward().Info.checkAmounts(); err != nil {
+		return err
+	} else if err := checkRules(description); err != nil {
+		return err
+	} else if err := description.checkArbiter(); err != nil {
+		return err
+	} else if _, err := verifier(description.ProofScheme); err != nil {
+		return err
+	} else if description.ExpiryHeight < 0 {
+		return ErrInvalidExpiry.Wrap("negative height")
+	} else if description.ExpiryTime < 0 {
+		return ErrInvalidExpiry.Wrap("negative time")
 	} else {
 		return nil
 	}
 }
 
-func (description *Description) isSigned() bool {
-	id := append(description.ProviderInfo, description.DataInfo...)
-	return crypto.Verify(description.Requirer, id, description.Signature)
+// Number of acceptances needed to accept a payload
+func (description *Description) threshold() int64 {
+	if description.AcceptorThreshold == 0 {
+		return 1
+	}
+	return description.AcceptorThreshold
+}
+
+func (description Description) checkArbiter() error {
+	if description.ChallengeWindow == 0 {
+		return nil // no disputes, no arbiter needed
+	}
+	return checkPubKey("arbiter", description.Arbiter)
+}
+
+func (description *Description) SignBytes(chainID string) []byte {
+	unsigned := *description
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeAddData, unsigned)
+}
+
+func (description *Description) isSigned(chainID string) bool {
+	return crypto.Verify(description.Requirer, description.SignBytes(chainID), description.Signature)
+}
+
+func (description *Description) expired(height, time int64) bool {
+	return (description.ExpiryHeight > 0 && height >= description.ExpiryHeight) ||
+		(description.ExpiryTime > 0 && time >= description.ExpiryTime)
 }
 
 func (description *Description) reward() Reward {
 	return Reward{
 		Info: &RewardInfo{
 			Requirer:        description.Requirer,
-			Validator:       description.Validator,
-			Acceptor:        description.Acceptor,
+			Validators:      description.Validators,
+			Acceptors:       description.Acceptors,
 			ValidatorAmount: description.ValidatorAmount,
 			ProviderAmount:  description.ProviderAmount,
 			AcceptorAmount:  description.AcceptorAmount,
@@ -248,129 +598,398 @@ func (description *Description) reward() Reward {
 	}
 }
 
+// ------------------------------------------------------------------------------------------------------------------- //
+// CLOSE DATA
+
+/*
+Closes a data, no more versions, payloads or acceptances will be added and the rewards not confirmed yet
+are returned to the requirer. The Requirer must be the secp256k1 public key of the data owner (description.Requirer),
+DataIndex the index of the data closed: the signature of the requirer closes this data only.
+The Signature must be a valid Signature of the close data sign bytes for the given key.
+*/
+type CloseData struct {
+	Requirer  []byte
+	DataIndex int
+	Nonce     int64
+	Signature []byte
+}
+
+func (closeData *CloseData) Verify(chainID string) error {
+	if err := closeData.check(); err != nil {
+		return err
+	}
+	if !closeData.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("close data")
+	}
+	return nil
+}
+
+func (closeData *CloseData) Payer() []byte {
+	return closeData.Requirer
+}
+
+func (closeData *CloseData) GetNonce() int64 {
+	return closeData.Nonce
+}
+
+func (closeData *CloseData) check() error {
+	return checkPubKey("requirer", closeData.Requirer)
+}
+
+func (closeData *CloseData) SignBytes(chainID string) []byte {
+	unsigned := *closeData
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeCloseData, unsigned)
+}
+
+func (closeData *CloseData) isSigned(chainID string) bool {
+	return crypto.Verify(closeData.Requirer, closeData.SignBytes(chainID), closeData.Signature)
+}
+
 // ------------------------------------------------------------------------------------------------------------------- //
 // VERSION
 
 /*	A version of data ... */
 type Version struct {
-	AcceptedPayload *AcceptedPayload
+	RejectedPayload *RejectedPayload   // set instead of AcceptedPayload when the acceptor rejects the payload
+	AcceptedPayload *AcceptedPayload   // the acceptance reaching the threshold, empty until then
+	Acceptances     []*AcceptedPayload // every acceptance, one for each acceptor
 	Payload         *Payload
 	Validation      *Validation
+	Confirm         int         // index of the reward confirm, when the payload is accepted
+	ReleaseHeight   int64       // end of the challenge window, 0 if the reward was paid at acceptance
+	Dispute         *Dispute    // opened by the requirer during the challenge window
+	Resolution      *Resolution // decided by the arbiter
+	Reveal          *Reveal     // accepting the payload by the rules of the description, instead of AcceptedPayload
+}
+
+func (version *Version) rejected() bool {
+	return (version.RejectedPayload != nil && !version.RejectedPayload.IsEmpty()) ||
+		(version.Resolution != nil && version.Resolution.Refund)
+}
+
+// Whether the version is validated and waits for its payload
+func (version *Version) AwaitingPayload() bool {
+	return version.Payload.IsEmpty() && !version.rejected()
+}
+
+// Whether the payload is provided and waits to be accepted or rejected
+func (version *Version) AwaitingAcceptance() bool {
+	return !version.Payload.IsEmpty() && !version.rejected() && !version.accepted()
 }
 
-func (version *Version) Hash() []byte {
-	sum := append(version.AcceptedPayload.Hash(), version.Payload.Hash()...)
-	sum = append(sum, version.Validation.Hash()...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
+// Whether the payload was accepted, by the acceptors or by the rules
+func (version *Version) accepted() bool {
+	return !version.AcceptedPayload.IsEmpty() || version.Reveal != nil
 }
 
-func (version *Version) prove(payload *Payload) bool {
-	proof := sha256.Sum256(payload.Proof)
-	info := version.Validation.Info
-	return bytes.Compare(proof[:], info) == 0
+func (version *Version) prove(verifier ProofVerifier, payload *Payload) bool {
+	return verifier.Verify(version.Validation.Info, payload.Proof, payload.ProviderAddr)
+}
+
+func (version *Version) hasAccepted(acceptor []byte) bool {
+	for _, acceptance := range version.Acceptances {
+		if bytes.Compare(acceptance.AcceptorAddr, acceptor) == 0 {
+			return true
+		}
+	}
+	return false
 }
 
-func (version *Version) rewardConfirm() *RewardConfirm {
-	return &RewardConfirm{
-		Provider: version.Payload.ProviderAddr,
+// The participants to the version, with the last acceptor
+func (version *Version) rewardConfirm(last []byte) *RewardConfirm {
+	confirm := &RewardConfirm{
+		Provider:  version.Payload.ProviderAddr,
+		Validator: version.Validation.ValidatorAddr,
 	}
+	for _, acceptance := range version.Acceptances {
+		confirm.Acceptors = append(confirm.Acceptors, acceptance.AcceptorAddr)
+	}
+	confirm.Acceptors = append(confirm.Acceptors, last)
+	return confirm
 }
 
 // ------------------------------------------------------------------------------------------------------------------- //
 // ACCEPTED PAYLOAD
 
-/*	Contains the actual data encrypted with Requirer secp256k1 public key. The data is considered provided and verified.
-	The Acceptor address must be the secp256k1 public key provided (description.Acceptor).
-	The Signature must be a valid Signature of (acceptedPayload.data) for the given key
-	Contains only arrays of bytes. Can be hashed by adding hashes of every field and hashing the result.
-	Can be empty / uninitialized. */
+/*
+Contains the actual data encrypted with Requirer secp256k1 public key (crypto.Reencrypt of the payload data).
+The data is considered provided and verified.
+Like in the payload, the data can be stored off-chain and referenced by ContentHash and ContentSize.
+The Commitment must be the same as the one signed by the provider in the payload,
+the requirer can check it against the decrypted data.
+The Acceptor address must be one of the secp256k1 public keys provided (description.Acceptors).
+DataIndex and VersionIndex are the indexes of the version accepted.
+The Signature must be a valid Signature of the accepted payload sign bytes for the given key
+Contains only arrays of bytes. Can be hashed by adding hashes of every field and hashing the result.
+Can be empty / uninitialized.
+*/
 type AcceptedPayload struct {
 	Data         []byte // encrypted with Requirer, when decrypted by Requirer should be encrypted with acceptorAddr to check if it's the same as in payload
+	Commitment   []byte // crypto.Commitment of the plaintext data
+	ContentHash  []byte // sha256 of the encrypted data stored off-chain, instead of Data
+	ContentSize  int64  // size of the encrypted data stored off-chain
 	AcceptorAddr []byte // public key representing Acceptor address, should be the same as in the description
+	DataIndex    int    // indexes of the version accepted, signed
+	VersionIndex int
+	Nonce        int64  // next nonce of acceptorAddr
 	Signature    []byte // confirming acceptorAddr
 }
 
-func (acceptedPayload *AcceptedPayload) Hash() []byte {
-	sum := append(acceptedPayload.Data, acceptedPayload.AcceptorAddr...)
-	sum = append(sum, acceptedPayload.Signature...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
-}
 func (acceptedPayload *AcceptedPayload) IsEmpty() bool {
 	return acceptedPayload.Data == nil && acceptedPayload.AcceptorAddr == nil && acceptedPayload.Signature == nil
 }
 
+func (acceptedPayload *AcceptedPayload) Verify(chainID string) error {
+	if err := acceptedPayload.check(); err != nil {
+		return err
+	}
+	if !acceptedPayload.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("accepted payload")
+	}
+	return nil
+}
+
+func (acceptedPayload *AcceptedPayload) Payer() []byte {
+	return acceptedPayload.AcceptorAddr
+}
+
+func (acceptedPayload *AcceptedPayload) GetNonce() int64 {
+	return acceptedPayload.Nonce
+}
+
 func (acceptedPayload *AcceptedPayload) check() error {
-	return crypto.CheckPubKey(acceptedPayload.AcceptorAddr)
+	if err := checkPubKey("acceptor", acceptedPayload.AcceptorAddr); err != nil {
+		return err
+	} else if err := checkCommitment(acceptedPayload.Commitment); err != nil {
+		return err
+	}
+	return checkContent(acceptedPayload.Data, acceptedPayload.ContentHash, acceptedPayload.ContentSize)
 }
 
-func (acceptedPayload *AcceptedPayload) isSigned() bool {
-	return crypto.Verify(acceptedPayload.AcceptorAddr, acceptedPayload.Data, acceptedPayload.Signature)
+func (acceptedPayload *AcceptedPayload) references(hash []byte) bool {
+	return acceptedPayload.ContentHash != nil && bytes.Compare(acceptedPayload.ContentHash, hash) == 0
+}
+
+func (acceptedPayload *AcceptedPayload) SignBytes(chainID string) []byte {
+	unsigned := *acceptedPayload
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeAcceptPayload, unsigned)
+}
+
+func (acceptedPayload *AcceptedPayload) isSigned(chainID string) bool {
+	return crypto.Verify(acceptedPayload.AcceptorAddr, acceptedPayload.SignBytes(chainID), acceptedPayload.Signature)
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// REJECTED PAYLOAD
+
+/*
+Rejects a payload not conforming to the data requested, with a reason readable by requirer and provider.
+The Acceptor address must be one of the secp256k1 public keys provided (description.Acceptors),
+or the requirer if the description lists none. DataIndex and VersionIndex are the indexes of the version rejected.
+The Signature must be a valid Signature of the rejected payload sign bytes for the given key.
+Can be empty / uninitialized.
+*/
+type RejectedPayload struct {
+	Reason       string
+	AcceptorAddr []byte // public key representing Acceptor address, should be the same as in the description
+	DataIndex    int    // indexes of the version rejected, signed
+	VersionIndex int
+	Nonce        int64  // next nonce of acceptorAddr
+	Signature    []byte // confirming acceptorAddr
+}
+
+func (rejectedPayload *RejectedPayload) IsEmpty() bool {
+	return rejectedPayload.Reason == "" && rejectedPayload.AcceptorAddr == nil && rejectedPayload.Signature == nil
+}
+
+func (rejectedPayload *RejectedPayload) Verify(chainID string) error {
+	if err := rejectedPayload.check(); err != nil {
+		return err
+	}
+	if !rejectedPayload.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("rejected payload")
+	}
+	return nil
+}
+
+func (rejectedPayload *RejectedPayload) Payer() []byte {
+	return rejectedPayload.AcceptorAddr
+}
+
+func (rejectedPayload *RejectedPayload) GetNonce() int64 {
+	return rejectedPayload.Nonce
+}
+
+func (rejectedPayload *RejectedPayload) check() error {
+	return checkPubKey("acceptor", rejectedPayload.AcceptorAddr)
+}
+
+func (rejectedPayload *RejectedPayload) SignBytes(chainID string) []byte {
+	unsigned := *rejectedPayload
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeRejectPayload, unsigned)
+}
+
+func (rejectedPayload *RejectedPayload) isSigned(chainID string) bool {
+	return crypto.Verify(rejectedPayload.AcceptorAddr, rejectedPayload.SignBytes(chainID), rejectedPayload.Signature)
 }
 
 // ------------------------------------------------------------------------------------------------------------------- //
 // PAYLOAD
 
-/*	Contains the actual data encrypted with Acceptor secp256k1 public key. The data is considered provided, but not verified.
-	The zero knowledge proof must be an arbitrary info or seed known to both validator and provider hashed n-1 times,
-	if (hash(payload.proof) != validation.info) then the payload wont be accepted!
-	The provider address can be any secp256k1 public key.
-	The Signature must be a valid Signature of (payload.data + payload.proof) for the given key.
-	Contains only arrays of bytes. Can be hashed by adding hashes of every field and hashing the result.
-	Can be empty / uninitialized. */
+/*
+Contains the actual data encrypted with Acceptor secp256k1 public key (crypto.Encrypt). The data is considered provided,
+but not verified. Contains the Commitment of the plaintext data (crypto.Commitment, or FieldsCommitment if the
+description has Rules), signed by the provider with the payload: the acceptor can check it against the decrypted data
+and the accepted payload must commit to the same plaintext, the chain checks it against this one.
+Instead of Data, can contain the ContentHash (sha256) and ContentSize of the encrypted data, stored off-chain
+in the blob stores of the nodes, which accept it only once the payload is committed.
+The zero knowledge proof is checked against validation.info by the verifier of description.ProofScheme,
+with the legacy hash chain it must be an arbitrary info or seed known to both validator and provider hashed n-1 times,
+if (hash(payload.proof) != validation.info) then the payload wont be accepted!
+With schnorr it must be a proof of knowledge of the secret of validation.info bound to the provider address.
+The provider address can be any secp256k1 public key. DataIndex and VersionIndex are the indexes of the version provided.
+The Signature must be a valid Signature of the payload sign bytes for the given key.
+Contains only arrays of bytes. Can be hashed by adding hashes of every field and hashing the result.
+Can be empty / uninitialized.
+*/
 type Payload struct {
 	Data         []byte
+	Commitment   []byte
+	ContentHash  []byte
+	ContentSize  int64
 	Proof        []byte
 	ProviderAddr []byte
+	DataIndex    int
+	VersionIndex int
+	Nonce        int64
 	Signature    []byte
 }
 
-func (payload *Payload) Hash() []byte {
-	sum := append(payload.Data, payload.Proof...)
-	sum = append(sum, payload.ProviderAddr...)
-	sum = append(sum, payload.Signature...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
-}
-
 func (payload *Payload) IsEmpty() bool {
 	return payload.Data == nil && payload.Proof == nil && payload.ProviderAddr == nil && payload.Signature == nil
 }
 
+func (payload *Payload) Verify(chainID string) error {
+	if err := payload.check(); err != nil {
+		return err
+	}
+	if !payload.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("payload")
+	}
+	return nil
+}
+
+func (payload *Payload) Payer() []byte {
+	return payload.ProviderAddr
+}
+
+func (payload *Payload) GetNonce() int64 {
+	return payload.Nonce
+}
+
 func (payload *Payload) check() error {
-	return crypto.CheckPubKey(payload.ProviderAddr)
+	if err := checkPubKey("provider", payload.ProviderAddr); err != nil {
+		return err
+	} else if err := checkCommitment(payload.Commitment); err != nil {
+		return err
+	}
+	return checkContent(payload.Data, payload.ContentHash, payload.ContentSize)
+}
+
+func checkCommitment(commitment []byte) error {
+	if len(commitment) != sha256.Size {
+		return ErrMissingCommitment
+	}
+	return nil
+}
+
+func (payload *Payload) references(hash []byte) bool {
+	return payload.ContentHash != nil && bytes.Compare(payload.ContentHash, hash) == 0
+}
+
+// Largest off-chain content a payload or an accepted payload can reference, in bytes
+const MaxContentSize = 64 * 1024 * 1024
+
+// Data is either on-chain or referenced by hash and size
+func checkContent(data, contentHash []byte, contentSize int64) error {
+	if contentHash == nil {
+		if contentSize != 0 {
+			return ErrInvalidContent.Wrap("size without hash")
+		}
+		return nil
+	}
+	if len(contentHash) != sha256.Size {
+		return ErrInvalidContent.Wrap("hash length")
+	} else if contentSize <= 0 {
+		return ErrInvalidContent.Wrap("size")
+	} else if contentSize > MaxContentSize {
+		return ErrInvalidContent.Wrap("size over " + strconv.Itoa(MaxContentSize) + " bytes")
+	} else if len(data) > 0 {
+		return ErrInvalidContent.Wrap("both data and hash")
+	}
+	return nil
 }
 
-func (payload *Payload) isSigned() bool {
-	return crypto.Verify(payload.ProviderAddr, append(payload.Data, payload.Proof...), payload.Signature)
+func (payload *Payload) SignBytes(chainID string) []byte {
+	unsigned := *payload
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeAddPayload, unsigned)
+}
+
+func (payload *Payload) isSigned(chainID string) bool {
+	return crypto.Verify(payload.ProviderAddr, payload.SignBytes(chainID), payload.Signature)
 }
 
 // ------------------------------------------------------------------------------------------------------------------- //
 // VALIDATION
 
-/*	An arbitrary info or seed known to both validator and provider hashed n times, could be an official ID number,
-	is needed for zero knowledge proof of validation identity.
-	The validator address must be one of secp256k1 public keys provided in (description.Validator).
-	The Signature must be a valid Signature of (validation.info) for the given key.
-	Contains only arrays of bytes. Can be hashed by adding hashes of every field and hashing the result. */
+/*
+An arbitrary info or seed known to both validator and provider hashed n times, could be an official ID number,
+is needed for zero knowledge proof of validation identity. With schnorr proofs, the point of such a secret.
+The validator address must be one of secp256k1 public keys provided in (description.Validators),
+DataIndex the index of the data validated.
+The Signature must be a valid Signature of the validation sign bytes for the given key.
+Contains only arrays of bytes. Can be hashed by adding hashes of every field and hashing the result.
+*/
 type Validation struct {
 	Info          []byte
 	ValidatorAddr []byte
+	DataIndex     int
+	Nonce         int64
 	Signature     []byte
 }
 
-func (validation *Validation) Hash() []byte {
-	sum := append(validation.Info, validation.ValidatorAddr...)
-	sum = append(sum, validation.Signature...)
-	hash := sha256.Sum256(sum)
-	return hash[:]
+func (validation *Validation) Verify(chainID string) error {
+	if err := validation.check(); err != nil {
+		return err
+	}
+	if !validation.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("validation")
+	}
+	return nil
+}
+
+func (validation *Validation) Payer() []byte {
+	return validation.ValidatorAddr
+}
+
+func (validation *Validation) GetNonce() int64 {
+	return validation.Nonce
 }
 
 func (validation *Validation) check() error {
-	return crypto.CheckPubKey(validation.ValidatorAddr)
+	return checkPubKey("validator", validation.ValidatorAddr)
+}
+
+func (validation *Validation) SignBytes(chainID string) []byte {
+	unsigned := *validation
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeAddValidation, unsigned)
 }
 
-func (validation *Validation) isSigned() bool {
-	return crypto.Verify(validation.ValidatorAddr, validation.Info, validation.Signature)
+func (validation *Validation) isSigned(chainID string) bool {
+	return crypto.Verify(validation.ValidatorAddr, validation.SignBytes(chainID), validation.Signature)
 }
@@ -0,0 +1,199 @@
+package modules
+
+import (
+	"bytes"
+	"dbc-node/crypto"
+)
+
+/*
+An accepted payload of a data with a challenge window can be disputed by the requirer until the window ends,
+the rewards of the version are then held until the arbiter of the data resolves the dispute:
+paid to validator, provider and acceptor, or refunded, leaving the version slot to a new one.
+*/
+
+func (dataset *Dataset) OpenDispute(dispute *Dispute, dataIndex int, versionIndex int) error { // called at disputeTx
+	if err := dispute.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkIndexes(dispute.DataIndex, dispute.VersionIndex, dataIndex, versionIndex); err != nil {
+		return err
+	}
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if bytes.Compare(dispute.Requirer, data.Description.Requirer) != 0 {
+		return ErrNotApproved.Wrap("requirer")
+	}
+	version, err := data.version(versionIndex)
+	if err != nil {
+		return err
+	}
+	if version.Dispute != nil {
+		return ErrDisputeExists
+	}
+	if !version.accepted() || version.ReleaseHeight == 0 || dataset.height >= version.ReleaseHeight {
+		return ErrChallengeClosed
+	}
+	if err := dataset.balance.DisputeReward(data.Reward, version.Confirm); err != nil {
+		return err
+	}
+	version.Dispute = dispute
+	dataset.balance.emit(EventOpenDispute,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(versionIndex)),
+		keyAttribute(AttributeRequirer, dispute.Requirer),
+		keyAttribute(AttributeArbiter, data.Description.Arbiter),
+		stringAttribute(AttributeReason, dispute.Reason))
+	return nil
+}
+
+func (dataset *Dataset) ResolveDispute(resolution *Resolution, dataIndex int, versionIndex int) error { // called at resolveTx
+	if err := resolution.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkIndexes(resolution.DataIndex, resolution.VersionIndex, dataIndex, versionIndex); err != nil {
+		return err
+	}
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if bytes.Compare(resolution.Arbiter, data.Description.Arbiter) != 0 {
+		return ErrNotApproved.Wrap("arbiter")
+	}
+	version, err := data.version(versionIndex)
+	if err != nil {
+		return err
+	}
+	if version.Dispute == nil {
+		return ErrMissingDispute
+	}
+	if version.Resolution != nil {
+		return ErrDisputeResolved
+	}
+	if resolution.Refund {
+		err = dataset.balance.RefundReward(data.Reward, version.Confirm)
+	} else {
+		err = dataset.balance.ReleaseReward(data.Reward, version.Confirm)
+	}
+	if err != nil {
+		return err
+	}
+	version.Resolution = resolution
+	dataset.balance.emit(EventResolveDispute,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(versionIndex)),
+		keyAttribute(AttributeArbiter, resolution.Arbiter),
+		stringAttribute(AttributeRuling, resolution.ruling()),
+		stringAttribute(AttributeReason, resolution.Reason))
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// DISPUTE
+
+/*
+Contests the acceptance of a payload, with a reason readable by the arbiter.
+The Requirer must be the secp256k1 public key of the data owner (description.Requirer).
+DataIndex and VersionIndex are the indexes of the version disputed, so that the rewards held are the ones the requirer chose.
+The Signature must be a valid Signature of the dispute sign bytes for the given key.
+*/
+type Dispute struct {
+	Reason       string
+	Requirer     []byte
+	DataIndex    int
+	VersionIndex int
+	Nonce        int64
+	Signature    []byte
+}
+
+func (dispute *Dispute) Verify(chainID string) error {
+	if err := dispute.check(); err != nil {
+		return err
+	}
+	if !dispute.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("dispute")
+	}
+	return nil
+}
+
+func (dispute *Dispute) Payer() []byte {
+	return dispute.Requirer
+}
+
+func (dispute *Dispute) GetNonce() int64 {
+	return dispute.Nonce
+}
+
+func (dispute *Dispute) check() error {
+	return checkPubKey("requirer", dispute.Requirer)
+}
+
+func (dispute *Dispute) SignBytes(chainID string) []byte {
+	unsigned := *dispute
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeOpenDispute, unsigned)
+}
+
+func (dispute *Dispute) isSigned(chainID string) bool {
+	return crypto.Verify(dispute.Requirer, dispute.SignBytes(chainID), dispute.Signature)
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// RESOLUTION
+
+/*
+Resolves a dispute: with Refund the rewards of the version are refunded, otherwise they are paid.
+The Arbiter must be the secp256k1 public key named in the description (description.Arbiter).
+DataIndex and VersionIndex are the indexes of the version whose dispute is resolved.
+The Signature must be a valid Signature of the resolution sign bytes for the given key.
+*/
+type Resolution struct {
+	Refund       bool
+	Reason       string
+	Arbiter      []byte
+	DataIndex    int
+	VersionIndex int
+	Nonce        int64
+	Signature    []byte
+}
+
+func (resolution *Resolution) Verify(chainID string) error {
+	if err := resolution.check(); err != nil {
+		return err
+	}
+	if !resolution.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("resolution")
+	}
+	return nil
+}
+
+func (resolution *Resolution) Payer() []byte {
+	return resolution.Arbiter
+}
+
+func (resolution *Resolution) GetNonce() int64 {
+	return resolution.Nonce
+}
+
+func (resolution *Resolution) check() error {
+	return checkPubKey("arbiter", resolution.Arbiter)
+}
+
+func (resolution *Resolution) SignBytes(chainID string) []byte {
+	unsigned := *resolution
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeResolveDispute, unsigned)
+}
+
+func (resolution *Resolution) isSigned(chainID string) bool {
+	return crypto.Verify(resolution.Arbiter, resolution.SignBytes(chainID), resolution.Signature)
+}
+
+func (resolution *Resolution) ruling() string {
+	if resolution.Refund {
+		return "refund"
+	}
+	return "release"
+}
@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codespace of every error code below, returned in the ABCI responses together with the code
+const Codespace = "dbc"
+
+// Error is a typed error with a stable numeric code, more detail can be added with Wrap
+type Error struct {
+	Code    uint32
+	Message string
+}
+
+var registry = make(map[uint32]*Error)
+
+func register(code uint32, message string) *Error {
+	if _, ok := registry[code]; ok {
+		panic(fmt.Sprintf("error code %d already registered", code))
+	}
+	err := &Error{Code: code, Message: message}
+	registry[code] = err
+	return err
+}
+
+// Codes are part of the protocol: never change or reuse them, only add new ones
+var (
+	ErrInternal            = register(1, "internal error")
+	ErrTxDecode            = register(2, "invalid transaction encoding")
+	ErrUnknownTxType       = register(3, "unknown transaction type")
+	ErrMissingContent      = register(4, "missing transaction content")
+	ErrInvalidPubKey       = register(5, "invalid public key")
+	ErrInvalidSignature    = register(6, "invalid signature")
+	ErrInvalidNonce        = register(7, "invalid nonce")
+	ErrInvalidAmount       = register(8, "invalid amount")
+	ErrInsufficientBalance = register(9, "insufficient balance")
+	ErrInsufficientStake   = register(10, "insufficient stake")
+	ErrUnknownData         = register(11, "unknown data")
+	ErrUnknownVersion      = register(12, "unknown version")
+	ErrUnknownReward       = register(13, "unknown reward")
+	ErrNotApproved         = register(14, "not approved")
+	ErrMaxVersions         = register(15, "reached max versions limit")
+	ErrInvalidProof        = register(16, "invalid payload proof")
+	ErrPayloadExists       = register(17, "payload already exists")
+	ErrMissingPayload      = register(18, "missing payload")
+	ErrAcceptedExists      = register(19, "accepted payload already exists")
+	ErrRewardClosed        = register(20, "reached max confirms limit or reward is closed")
+	ErrUnknownPath         = register(21, "unknown query path")
+	ErrInvalidArgument     = register(22, "invalid query argument")
+	ErrNotFound            = register(23, "not found")
+	ErrDataClosed          = register(24, "data is closed")
+	ErrInvalidExpiry       = register(25, "invalid expiry")
+	ErrDataExpired         = register(26, "data is expired")
+	ErrPayloadRejected     = register(27, "payload was rejected")
+	ErrRewardState         = register(28, "invalid reward state")
+	ErrChallengeClosed     = register(29, "challenge window is closed")
+	ErrDisputeExists       = register(30, "dispute already exists")
+	ErrMissingDispute      = register(31, "missing dispute")
+	ErrDisputeResolved     = register(32, "dispute already resolved")
+	ErrUnknownProofScheme  = register(33, "unknown proof scheme")
+	ErrCommitmentMismatch  = register(34, "commitment doesn't match the payload")
+	ErrInvalidContent      = register(35, "invalid content reference")
+	ErrInvalidSchema       = register(36, "invalid schema")
+	ErrUnknownSchema       = register(37, "unknown schema")
+	ErrSchemaExists        = register(38, "schema already exists")
+	ErrSchemaMismatch      = register(39, "content doesn't match the schema")
+	ErrInvalidRule         = register(40, "invalid verification rule")
+	ErrRuleFailed          = register(41, "verification rule failed")
+	ErrNoRules             = register(42, "data has no verification rules")
+	ErrInvalidGenesis      = register(43, "invalid genesis")
+	ErrInvalidReveal       = register(44, "invalid reveal")
+	ErrAwaitingAcceptance  = register(45, "payload awaiting acceptance")
+	ErrMissingCommitment   = register(46, "missing commitment")
+	ErrIndexMismatch       = register(47, "message signed for another data or version")
+)
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// Adds detail to the error message, the result still carries the error code
+func (err *Error) Wrap(detail string) error {
+	return fmt.Errorf("%w: %s", err, detail)
+}
+
+// Returns the code of a typed error, 0 for no error and ErrInternal code for untyped errors
+func ErrorCode(err error) uint32 {
+	if err == nil {
+		return 0
+	}
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Code
+	}
+	return ErrInternal.Code
+}
@@ -0,0 +1,97 @@
+package modules
+
+import (
+	"encoding/hex"
+	tendermint "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/kv"
+	"strconv"
+)
+
+/*
+Every successful state transition emits an event, returned in the ABCI responses so clients can
+subscribe to them and search them with tx_search, e.g. "accept_payload.acceptor='<hex>'".
+Public keys are hex encoded, indexes and amounts are decimal.
+*/
+
+// Event types
+const (
+	EventAddData        = "add_data"
+	EventAddValidation  = "add_validation"
+	EventAddPayload     = "add_payload"
+	EventAcceptPayload  = "accept_payload"
+	EventRejectPayload  = "reject_payload"
+	EventCloseData      = "close_data"
+	EventOpenDispute    = "open_dispute"
+	EventResolveDispute = "resolve_dispute"
+	EventRevealPayload  = "reveal_payload"
+	EventRegisterSchema = "register_schema"
+	EventExpireData     = "expire_data"
+	EventTransfer       = "transfer"
+	EventStake          = "stake"
+	EventConfirmReward  = "confirm_reward"
+	EventCloseReward    = "close_reward"
+	EventHoldReward     = "hold_reward"
+	EventRefundReward   = "refund_reward"
+)
+
+// Event attribute keys
+const (
+	AttributeRequirer        = "requirer"
+	AttributeValidator       = "validator"
+	AttributeProvider        = "provider"
+	AttributeAcceptor        = "acceptor"
+	AttributeArbiter         = "arbiter"
+	AttributeSender          = "sender"
+	AttributeReceiver        = "receiver"
+	AttributeUser            = "user"
+	AttributeDataIndex       = "data_index"
+	AttributeVersionIndex    = "version_index"
+	AttributeRewardIndex     = "reward_index"
+	AttributeConfirmIndex    = "confirm_index"
+	AttributeReleaseHeight   = "release_height"
+	AttributeAmount          = "amount"
+	AttributeValidatorAmount = "validator_amount"
+	AttributeProviderAmount  = "provider_amount"
+	AttributeAcceptorAmount  = "acceptor_amount"
+	AttributeRefund          = "refund"
+	AttributeReason          = "reason"
+	AttributeRuling          = "ruling"
+	AttributeAcceptances     = "acceptances"
+	AttributeSchema          = "schema"
+	AttributeOwner           = "owner"
+)
+
+func (balance *Balance) emit(eventType string, attributes ...kv.Pair) {
+	balance.events = append(balance.events, tendermint.Event{
+		Type:       eventType,
+		Attributes: attributes,
+	})
+}
+
+// Returns the events emitted since the last call, and forgets them
+func (balance *Balance) Events() []tendermint.Event {
+	events := balance.events
+	balance.events = nil
+	return events
+}
+
+func keyAttribute(key string, pubKey []byte) kv.Pair {
+	return kv.Pair{Key: []byte(key), Value: []byte(hex.EncodeToString(pubKey))}
+}
+
+// One attribute for each key, so that each one can be searched
+func keysAttribute(key string, pubKeys [][]byte) []kv.Pair {
+	var attributes []kv.Pair
+	for _, pubKey := range pubKeys {
+		attributes = append(attributes, keyAttribute(key, pubKey))
+	}
+	return attributes
+}
+
+func stringAttribute(key string, value string) kv.Pair {
+	return kv.Pair{Key: []byte(key), Value: []byte(value)}
+}
+
+func intAttribute(key string, value int64) kv.Pair {
+	return kv.Pair{Key: []byte(key), Value: []byte(strconv.FormatInt(value, 10))}
+}
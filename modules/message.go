@@ -0,0 +1,118 @@
+package modules
+
+import (
+	"bytes"
+	"dbc-node/crypto"
+	"encoding/json"
+	"strconv"
+)
+
+// Transaction types, bound into the sign bytes of their messages
+const (
+	TypeAddData        = "TxAddData"
+	TypeAddValidation  = "TxAddValidation"
+	TypeAddPayload     = "TxAddPayload"
+	TypeAcceptPayload  = "TxAcceptPayload"
+	TypeRejectPayload  = "TxRejectPayload"
+	TypeCloseData      = "TxCloseData"
+	TypeOpenDispute    = "TxOpenDispute"
+	TypeResolveDispute = "TxResolveDispute"
+	TypeRevealPayload  = "TxRevealPayload"
+	TypeRegisterSchema = "TxRegisterSchema"
+	TypeTransfer       = "TxTransfer"
+	TypeStake          = "TxStake"
+)
+
+// Sign doc type of the stake of a gentx, no transaction has it so a gentx can't be delivered on chain
+const TypeGenTx = "GenTx"
+
+// Message is the signed content of a transaction, it can be verified without any state
+type Message interface {
+	Verify(chainID string) error // checks the fields and the signature
+	Payer() []byte               // user paying the fee
+	GetNonce() int64             // must be the next nonce of the sender
+}
+
+var (
+	_ Message = (*Description)(nil)
+	_ Message = (*Validation)(nil)
+	_ Message = (*Payload)(nil)
+	_ Message = (*AcceptedPayload)(nil)
+	_ Message = (*RejectedPayload)(nil)
+	_ Message = (*CloseData)(nil)
+	_ Message = (*Dispute)(nil)
+	_ Message = (*Resolution)(nil)
+	_ Message = (*Reveal)(nil)
+	_ Message = (*Schema)(nil)
+	_ Message = (*Transfer)(nil)
+	_ Message = (*Stake)(nil)
+)
+
+/*
+The sign bytes of every message are the canonical JSON encoding of its sign doc:
+{"ChainID":"...","TxType":"...","Message":{...}}
+where Message holds every field of the message in declaration order, with a null Signature.
+Byte arrays are encoded in standard base64, numbers in decimal, strings as by encoding/json but without
+escaping <, > and & for HTML, so that any JSON encoder can produce the same bytes; no whitespace, no trailing newline.
+*/
+type signDoc struct {
+	ChainID string
+	TxType  string
+	Message interface{}
+}
+
+func signBytes(chainID, txType string, message interface{}) []byte {
+	var doc bytes.Buffer
+	encoder := json.NewEncoder(&doc)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(signDoc{
+		ChainID: chainID,
+		TxType:  txType,
+		Message: message,
+	})
+	return bytes.TrimSuffix(doc.Bytes(), []byte("\n"))
+}
+
+/*
+Messages acting on a data or a version sign their indexes, which must be the indexes of the transaction carrying them:
+a message seen in the mempool can't be wrapped again in a transaction for another data or version.
+*/
+func checkIndexes(signedData, signedVersion, dataIndex, versionIndex int) error {
+	if err := checkDataIndex(signedData, dataIndex); err != nil {
+		return err
+	}
+	if signedVersion != versionIndex {
+		return ErrIndexMismatch.Wrap("signed for version " + strconv.Itoa(signedVersion))
+	}
+	return nil
+}
+
+func checkDataIndex(signedData, dataIndex int) error {
+	if signedData != dataIndex {
+		return ErrIndexMismatch.Wrap("signed for data " + strconv.Itoa(signedData))
+	}
+	return nil
+}
+
+func checkPubKey(role string, pubKey []byte) error {
+	if err := crypto.CheckPubKey(pubKey); err != nil {
+		return ErrInvalidPubKey.Wrap(role + ": " + err.Error())
+	}
+	return nil
+}
+
+func checkPubKeys(role string, pubKeys [][]byte) error {
+	for _, pubKey := range pubKeys {
+		if err := checkPubKey(role, pubKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkEDPubKey(role string, pubKey []byte) error {
+	if err := crypto.CheckEDPubKey(pubKey); err != nil {
+		return ErrInvalidPubKey.Wrap(role + ": " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package modules
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dbc-node/crypto"
+)
+
+/*
+The proof of a payload shows that its provider was certified by the validator of the version,
+the info of the validation is checked against it by the verifier of the scheme chosen in the description.
+*/
+
+// Proof schemes, selectable by description.ProofScheme
+const (
+	ProofHashChain = ""        // legacy: sha256(proof) == info, reveals the proof on chain
+	ProofSchnorr   = "schnorr" // proof of knowledge of the secret of the info point, bound to the provider
+)
+
+// ProofVerifier checks the proofs of a scheme
+type ProofVerifier interface {
+	CheckInfo(info []byte) error              // called at validation
+	Verify(info, proof, provider []byte) bool // called at payload
+}
+
+var verifiers = map[string]ProofVerifier{
+	ProofHashChain: hashChainVerifier{},
+	ProofSchnorr:   schnorrVerifier{},
+}
+
+// Adds a proof scheme, must be called at startup by every node
+func RegisterVerifier(scheme string, verifier ProofVerifier) {
+	verifiers[scheme] = verifier
+}
+
+func verifier(scheme string) (ProofVerifier, error) {
+	verifier, ok := verifiers[scheme]
+	if !ok {
+		return nil, ErrUnknownProofScheme.Wrap(scheme)
+	}
+	return verifier, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// HASH CHAIN
+
+/*
+The info is an arbitrary seed known to both validator and provider hashed n times,
+the proof is the same seed hashed n-1 times.
+*/
+type hashChainVerifier struct{}
+
+func (hashChainVerifier) CheckInfo(info []byte) error {
+	return nil
+}
+
+func (hashChainVerifier) Verify(info, proof, provider []byte) bool {
+	hash := sha256.Sum256(proof)
+	return bytes.Compare(hash[:], info) == 0
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// SCHNORR
+
+/*
+The info is the point of a secret known to both validator and provider (crypto.KnowledgePoint),
+the proof is a proof of knowledge of the secret bound to the provider address (crypto.ProveKnowledge).
+*/
+type schnorrVerifier struct{}
+
+func (schnorrVerifier) CheckInfo(info []byte) error {
+	if err := crypto.CheckKnowledgePoint(info); err != nil {
+		return ErrInvalidProof.Wrap("info: " + err.Error())
+	}
+	return nil
+}
+
+func (schnorrVerifier) Verify(info, proof, provider []byte) bool {
+	return crypto.VerifyKnowledge(info, provider, proof)
+}
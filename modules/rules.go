@@ -0,0 +1,454 @@
+package modules
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dbc-node/crypto"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+A description can attach verification rules, checked against the plaintext of a payload by every node:
+once a reveal of the plaintext matches the payload commitment and passes every rule, it counts as the last acceptance
+needed and the rewards are confirmed as if the acceptors had accepted the payload. If the description lists acceptors,
+only one of them can send the reveal, and gets its share of the acceptor amount, otherwise anyone can send it
+and the acceptor amount goes to the provider, so that nobody gains from copying a reveal seen in the mempool.
+The payload commitment is the FieldsCommitment of the plaintext, a JSON object, and a reveal only discloses
+the fields the rules check, the other fields by their hash, unless a rule checks the whole plaintext.
+*/
+
+// Rule operators
+const (
+	RuleHash      = "hash"      // sha256 of the field equals Value
+	RuleEquals    = "equals"    // the field has the JSON value in Value
+	RuleSignature = "signature" // one of the certificates of the reveal is a signature of the field by Key
+	RuleSchema    = "schema"    // the plaintext matches description.DataSchema
+)
+
+// Most rules a description can attach
+const MaxRules = 16
+
+func (dataset *Dataset) RevealPayload(reveal *Reveal, dataIndex int, versionIndex int) error { // called at revealTx
+	if err := reveal.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if err := checkIndexes(reveal.DataIndex, reveal.VersionIndex, dataIndex, versionIndex); err != nil {
+		return err
+	}
+	data, err := dataset.data(dataIndex)
+	if err != nil {
+		return err
+	}
+	if err := data.open(dataset.height, dataset.time); err != nil {
+		return err
+	}
+	if len(data.Description.Rules) == 0 {
+		return ErrNoRules
+	}
+	version, err := data.version(versionIndex)
+	if err != nil {
+		return err
+	}
+	if version.Payload.IsEmpty() {
+		return ErrMissingPayload
+	}
+	if version.rejected() {
+		return ErrPayloadRejected
+	}
+	if version.accepted() {
+		return ErrAcceptedExists
+	}
+	if !isListed(reveal.Sender, data.Description.Acceptors) {
+		return ErrNotApproved.Wrap("sender")
+	}
+	if version.hasAccepted(reveal.Sender) {
+		return ErrAcceptedExists.Wrap("by the same acceptor")
+	}
+	if int64(len(version.Acceptances)+1) < data.Description.threshold() {
+		return ErrNotApproved.Wrap("acceptances below the threshold")
+	}
+	if err := reveal.checkDisclosure(data.Description.Rules); err != nil {
+		return err
+	}
+	if commitment, err := reveal.commitment(); err != nil || bytes.Compare(commitment, version.Payload.Commitment) != 0 {
+		return ErrCommitmentMismatch
+	}
+	document := reveal.document()
+	for i, rule := range data.Description.Rules {
+		if err := dataset.checkRule(rule, data.Description, document, reveal); err != nil {
+			return ErrRuleFailed.Wrap("rule " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	last := version.Payload.ProviderAddr // no acceptor to pay
+	if len(data.Description.Acceptors) > 0 {
+		last = reveal.Sender
+	}
+	confirm := version.rewardConfirm(last)
+	if window := data.Description.ChallengeWindow; window > 0 {
+		err, confirmIndex := dataset.balance.HoldReward(confirm, data.Reward, dataset.height+window)
+		if err != nil {
+			return err
+		}
+		version.Confirm = confirmIndex
+		version.ReleaseHeight = dataset.height + window
+	} else if err := dataset.balance.ConfirmReward(confirm, data.Reward); err != nil {
+		return err
+	}
+	version.Reveal = reveal
+	dataset.balance.emit(EventRevealPayload,
+		intAttribute(AttributeDataIndex, int64(dataIndex)),
+		intAttribute(AttributeVersionIndex, int64(versionIndex)),
+		keyAttribute(AttributeRequirer, data.Description.Requirer),
+		keyAttribute(AttributeProvider, version.Payload.ProviderAddr),
+		keyAttribute(AttributeSender, reveal.Sender))
+	return nil
+}
+
+// Checks the rule against the revealed document, the plaintext or its revealed fields
+func (dataset *Dataset) checkRule(rule Rule, description *Description, document []byte, reveal *Reveal) error {
+	if rule.Op == RuleSchema {
+		dataSchema, err := dataset.Schema(description.DataSchema)
+		if err != nil {
+			return err
+		}
+		return dataSchema.Validate(document)
+	}
+	subject, err := field(document, rule.Field)
+	if err != nil {
+		return err
+	}
+	switch rule.Op {
+	case RuleHash:
+		if hash := sha256.Sum256(subject); bytes.Compare(hash[:], rule.Value) != 0 {
+			return errors.New("hash of " + rule.Field)
+		}
+	case RuleEquals:
+		if expected, err := canonical(rule.Value); err != nil || bytes.Compare(subject, expected) != 0 {
+			return errors.New("value of " + rule.Field)
+		}
+	case RuleSignature:
+		if !reveal.isCertified(rule.Key, subject) {
+			return errors.New("no signature of " + rule.Field)
+		}
+	}
+	return nil
+}
+
+/*
+Returns the bytes of the field at the dot separated path of a JSON object, the whole document if the path is blank:
+the text of a string, the compact JSON encoding of any other value, with the keys of objects sorted.
+*/
+func field(document []byte, path string) ([]byte, error) {
+	if path == "" {
+		return document, nil
+	}
+	value, err := decodeJSON(document)
+	if err != nil {
+		return nil, errors.New("plaintext isn't JSON")
+	}
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("missing field " + path)
+		}
+		if value, ok = object[name]; !ok {
+			return nil, errors.New("missing field " + path)
+		}
+	}
+	return valueBytes(value)
+}
+
+// Returns the bytes of a JSON document as returned by field
+func canonical(document []byte) ([]byte, error) {
+	value, err := decodeJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	return valueBytes(value)
+}
+
+func valueBytes(value interface{}) ([]byte, error) {
+	if text, ok := value.(string); ok {
+		return []byte(text), nil
+	}
+	return json.Marshal(value)
+}
+
+/*
+Returns the commitment of a plaintext accepted by rules, a JSON object: the sha256 of the hashes of its fields
+in name order, each the sha256 of the sha256 of its name and of its JSON value as written in the plaintext.
+A field that could be guessed from its hash should be salted, e.g. a random string next to the value in an object.
+*/
+func FieldsCommitment(plaintext []byte) ([]byte, error) {
+	fields, err := splitFields(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return fieldsCommitment(fields), nil
+}
+
+// Returns the reveal of the plaintext for the rules, unsigned: the fields they check, or the whole plaintext
+func NewReveal(plaintext []byte, rules []Rule) (*Reveal, error) {
+	names, whole := ruleFields(rules)
+	if whole {
+		return &Reveal{Plaintext: plaintext}, nil
+	}
+	fields, err := splitFields(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	for i := range fields {
+		if !names[fields[i].Name] {
+			fields[i] = RevealedField{Name: fields[i].Name, Hash: fields[i].hash()}
+		}
+	}
+	return &Reveal{Fields: fields}, nil
+}
+
+// Returns the fields of a JSON object plaintext, revealed, in name order
+func splitFields(plaintext []byte) ([]RevealedField, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(plaintext, &object); err != nil || object == nil {
+		return nil, errors.New("plaintext isn't a JSON object")
+	}
+	fields := make([]RevealedField, 0, len(object))
+	for name, value := range object {
+		fields = append(fields, RevealedField{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+func fieldsCommitment(fields []RevealedField) []byte {
+	var sum []byte
+	for _, revealed := range fields {
+		sum = append(sum, revealed.hash()...)
+	}
+	hash := sha256.Sum256(sum)
+	return hash[:]
+}
+
+// Returns the top level fields of the plaintext the rules check, or whole if a rule checks the whole plaintext
+func ruleFields(rules []Rule) (names map[string]bool, whole bool) {
+	names = make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Op == RuleSchema || rule.Field == "" {
+			return nil, true
+		}
+		names[strings.Split(rule.Field, ".")[0]] = true
+	}
+	return names, false
+}
+
+func decodeJSON(document []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber() // numbers are kept as written
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// RULE
+
+/*
+A rule checks a field of the payload plaintext, a JSON object, or the whole plaintext if Field is blank.
+Value is the sha256 of the field for a hash rule and the JSON encoding of the expected value for an equals rule,
+Key is the secp256k1 public key of the certifier for a signature rule.
+*/
+type Rule struct {
+	Op    string
+	Field string
+	Value []byte
+	Key   []byte
+}
+
+func (rule Rule) check() error {
+	switch rule.Op {
+	case RuleHash:
+		if len(rule.Value) != sha256.Size {
+			return ErrInvalidRule.Wrap("hash length")
+		}
+	case RuleEquals:
+		if !json.Valid(rule.Value) {
+			return ErrInvalidRule.Wrap("value isn't JSON")
+		}
+	case RuleSignature:
+		if err := checkPubKey("certifier", rule.Key); err != nil {
+			return err
+		}
+	case RuleSchema:
+		if rule.Field != "" {
+			return ErrInvalidRule.Wrap("schema of a field")
+		}
+	default:
+		return ErrInvalidRule.Wrap("operator " + rule.Op)
+	}
+	return nil
+}
+
+func checkRules(description Description) error {
+	if len(description.Rules) > MaxRules {
+		return ErrInvalidRule.Wrap("more than " + strconv.Itoa(MaxRules) + " rules")
+	}
+	for _, rule := range description.Rules {
+		if err := rule.check(); err != nil {
+			return err
+		}
+		if rule.Op == RuleSchema && description.DataSchema == "" {
+			return ErrInvalidRule.Wrap("schema rule without data schema")
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// REVEAL
+
+/*
+Reveals the plaintext of a payload to accept it by the rules of its description, instead of by the acceptors.
+The Plaintext is set only if a rule checks the whole plaintext, otherwise the Fields of the plaintext are listed
+in name order, the fields the rules check with their Value, the others with their Hash only (see NewReveal).
+Either must match the commitment of the payload, the Certificates are the signatures checked by the signature rules.
+The Sender must be a secp256k1 public key, one of the acceptors of the description if it lists any.
+DataIndex and VersionIndex are the indexes of the version revealed.
+The Signature must be a valid Signature of the reveal sign bytes for the given key.
+*/
+type Reveal struct {
+	Plaintext    []byte
+	Fields       []RevealedField
+	Certificates [][]byte
+	Sender       []byte
+	DataIndex    int
+	VersionIndex int
+	Nonce        int64
+	Signature    []byte
+}
+
+func (reveal *Reveal) Verify(chainID string) error {
+	if err := reveal.check(); err != nil {
+		return err
+	}
+	if !reveal.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("reveal")
+	}
+	return nil
+}
+
+func (reveal *Reveal) Payer() []byte {
+	return reveal.Sender
+}
+
+func (reveal *Reveal) GetNonce() int64 {
+	return reveal.Nonce
+}
+
+func (reveal *Reveal) check() error {
+	if err := checkPubKey("sender", reveal.Sender); err != nil {
+		return err
+	}
+	if len(reveal.Certificates) > MaxRules {
+		return ErrInvalidRule.Wrap("more than " + strconv.Itoa(MaxRules) + " certificates")
+	}
+	if reveal.Plaintext != nil && len(reveal.Fields) > 0 {
+		return ErrInvalidReveal.Wrap("both plaintext and fields")
+	}
+	for i, revealed := range reveal.Fields {
+		if i > 0 && reveal.Fields[i-1].Name >= revealed.Name {
+			return ErrInvalidReveal.Wrap("fields not in name order")
+		}
+		if err := revealed.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the reveal discloses what the rules check and nothing else
+func (reveal *Reveal) checkDisclosure(rules []Rule) error {
+	names, whole := ruleFields(rules)
+	if whole != (reveal.Plaintext != nil) {
+		return ErrInvalidReveal.Wrap("the whole plaintext is revealed only if a rule checks it")
+	}
+	for _, revealed := range reveal.Fields {
+		if names[revealed.Name] != (len(revealed.Value) > 0) {
+			return ErrInvalidReveal.Wrap("field " + revealed.Name + " revealed only if a rule checks it")
+		}
+	}
+	return nil
+}
+
+func (reveal *Reveal) commitment() ([]byte, error) {
+	if reveal.Plaintext == nil {
+		return fieldsCommitment(reveal.Fields), nil
+	}
+	return FieldsCommitment(reveal.Plaintext)
+}
+
+// Returns the document the rules check, the plaintext or a JSON object of the revealed fields
+func (reveal *Reveal) document() []byte {
+	if reveal.Plaintext != nil {
+		return reveal.Plaintext
+	}
+	object := make(map[string]json.RawMessage)
+	for _, revealed := range reveal.Fields {
+		if len(revealed.Value) > 0 {
+			object[revealed.Name] = revealed.Value
+		}
+	}
+	document, _ := json.Marshal(object)
+	return document
+}
+
+func (reveal *Reveal) isCertified(certifier, subject []byte) bool {
+	for _, certificate := range reveal.Certificates {
+		if crypto.Verify(certifier, subject, certificate) {
+			return true
+		}
+	}
+	return false
+}
+
+func (reveal *Reveal) SignBytes(chainID string) []byte {
+	unsigned := *reveal
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeRevealPayload, unsigned)
+}
+
+func (reveal *Reveal) isSigned(chainID string) bool {
+	return crypto.Verify(reveal.Sender, reveal.SignBytes(chainID), reveal.Signature)
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// REVEALED FIELD
+
+// A top level field of a plaintext, revealed with its JSON Value as written in the plaintext, or hidden by its Hash
+type RevealedField struct {
+	Name  string
+	Value []byte
+	Hash  []byte
+}
+
+func (revealed RevealedField) check() error {
+	if len(revealed.Value) > 0 {
+		if len(revealed.Hash) > 0 || !json.Valid(revealed.Value) {
+			return ErrInvalidReveal.Wrap("value of field " + revealed.Name)
+		}
+	} else if len(revealed.Hash) != sha256.Size {
+		return ErrInvalidReveal.Wrap("hash of field " + revealed.Name)
+	}
+	return nil
+}
+
+func (revealed RevealedField) hash() []byte {
+	if len(revealed.Value) == 0 {
+		return revealed.Hash
+	}
+	name := sha256.Sum256([]byte(revealed.Name))
+	hash := sha256.Sum256(append(name[:], revealed.Value...))
+	return hash[:]
+}
@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"dbc-node/crypto"
+	jsonschema "dbc-node/schema"
+	"regexp"
+	"strings"
+)
+
+/*
+Schemas describe the shape of the provider info and of the payload plaintext of a description,
+they are registered once with an ID and then referenced by it from any description, like "invoice".
+The provider info is checked by the node, the payload plaintext is encrypted so it's checked by the acceptors
+with the schema package before accepting it.
+*/
+
+// Longest schema definition accepted, in bytes
+const MaxSchemaSize = 16 * 1024
+
+// IDs are used in query paths
+var schemaID = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+func (dataset *Dataset) RegisterSchema(schema *Schema) error { // called at schemaTx
+	if err := schema.Verify(dataset.balance.ChainID); err != nil {
+		return err
+	}
+	if _, ok := dataset.Schemas[schema.ID]; ok {
+		return ErrSchemaExists.Wrap(schema.ID)
+	}
+	if dataset.Schemas == nil {
+		dataset.Schemas = make(map[string]*Schema)
+	}
+	dataset.Schemas[schema.ID] = schema
+	dataset.balance.emit(EventRegisterSchema,
+		stringAttribute(AttributeSchema, schema.ID),
+		keyAttribute(AttributeOwner, schema.Owner))
+	return nil
+}
+
+// Returns the compiled schema registered with the ID
+func (dataset *Dataset) Schema(id string) (*jsonschema.Schema, error) {
+	registered, ok := dataset.Schemas[id]
+	if !ok {
+		return nil, ErrUnknownSchema.Wrap(id)
+	}
+	return registered.compile()
+}
+
+// Checks the schemas referenced by the description exist, and the provider info matches its schema
+func (dataset *Dataset) checkSchemas(description *Description) error {
+	if description.DataSchema != "" {
+		if _, err := dataset.Schema(description.DataSchema); err != nil {
+			return err
+		}
+	}
+	if description.ProviderSchema == "" {
+		return nil
+	}
+	providerSchema, err := dataset.Schema(description.ProviderSchema)
+	if err != nil {
+		return err
+	}
+	if err := providerSchema.Validate(description.ProviderInfo); err != nil {
+		return ErrSchemaMismatch.Wrap("provider info: " + err.Error())
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// SCHEMA
+
+/*
+Registers a schema under an ID, lower case letters, digits, '.', '_' and '-', unique on the chain.
+The Definition is a JSON Schema document, restricted to the keywords supported by the schema package.
+The Owner must be a secp256k1 public key, paying the fee; schemas can't be changed or removed.
+The Signature must be a valid Signature of the schema sign bytes for the given key.
+*/
+type Schema struct {
+	ID         string
+	Definition []byte
+	Owner      []byte
+	Nonce      int64
+	Signature  []byte
+}
+
+func (schema *Schema) Verify(chainID string) error {
+	if err := schema.check(); err != nil {
+		return err
+	}
+	if !schema.isSigned(chainID) {
+		return ErrInvalidSignature.Wrap("schema")
+	}
+	return nil
+}
+
+func (schema *Schema) Payer() []byte {
+	return schema.Owner
+}
+
+func (schema *Schema) GetNonce() int64 {
+	return schema.Nonce
+}
+
+func (schema *Schema) check() error {
+	if err := checkPubKey("owner", schema.Owner); err != nil {
+		return err
+	}
+	if !schemaID.MatchString(schema.ID) {
+		return ErrInvalidSchema.Wrap("id " + schema.ID)
+	}
+	if len(schema.Definition) > MaxSchemaSize {
+		return ErrInvalidSchema.Wrap("definition too large")
+	}
+	_, err := schema.compile()
+	return err
+}
+
+func (schema *Schema) compile() (*jsonschema.Schema, error) {
+	compiled, err := jsonschema.Compile(schema.Definition)
+	if err != nil {
+		return nil, ErrInvalidSchema.Wrap(strings.TrimPrefix(err.Error(), jsonschema.ErrInvalid.Error()+": "))
+	}
+	return compiled, nil
+}
+
+func (schema *Schema) SignBytes(chainID string) []byte {
+	unsigned := *schema
+	unsigned.Signature = nil
+	return signBytes(chainID, TypeRegisterSchema, unsigned)
+}
+
+func (schema *Schema) isSigned(chainID string) bool {
+	return crypto.Verify(schema.Owner, schema.SignBytes(chainID), schema.Signature)
+}
@@ -0,0 +1,432 @@
+package schema
+
+import (
+	"bytes"
+	"dbc-node/crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+A schema describes the shape of a JSON document with a subset of JSON Schema:
+type, enum, properties, required, additionalProperties, items, minimum, maximum,
+minLength, maxLength, pattern, minItems and maxItems, plus the annotations $schema, $id, title,
+description, default and examples. Any other keyword is refused rather than silently ignored,
+so a document valid here is valid for any JSON Schema validator.
+Patterns are Go regular expressions, RE2 syntax: unlike the ECMA 262 syntax of JSON Schema they have no
+lookarounds nor backreferences, schemas using them are refused with the reason.
+
+Schemas are registered on chain and referenced by ID from descriptions, the node checks the provider info
+against them, acceptors check the decrypted payloads with Decrypt before accepting them.
+*/
+
+var (
+	ErrInvalid  = errors.New("invalid schema")
+	ErrMismatch = errors.New("document doesn't match the schema")
+)
+
+// Types of JSON values
+const (
+	TypeNull    = "null"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeString  = "string"
+)
+
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+type Schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *Schema
+	Minimum              *big.Float
+	Maximum              *big.Float
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	MinItems             *int
+	MaxItems             *int
+}
+
+// Parses a schema definition
+func Compile(definition []byte) (*Schema, error) {
+	value, err := decode(definition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	return compile(value, "$")
+}
+
+// Checks the document matches the schema
+func (schema *Schema) Validate(document []byte) error {
+	value, err := decode(document)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMismatch, err.Error())
+	}
+	return schema.validate(value, "$")
+}
+
+// Decrypts a payload sent to privKey and checks the plaintext matches the schema, returns the plaintext
+func (schema *Schema) Decrypt(privKey, ciphertext []byte) ([]byte, error) {
+	plaintext, err := crypto.Decrypt(privKey, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(plaintext); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// Decodes a single JSON value, numbers are kept as json.Number so no precision is lost
+func decode(document []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("trailing data after the JSON value")
+	}
+	return value, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// COMPILE
+
+func compile(value interface{}, path string) (*Schema, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, invalid(path, "must be an object")
+	}
+	schema := &Schema{}
+	var err error
+	for _, keyword := range keys(object) {
+		field := object[keyword]
+		at := path + "." + keyword
+		switch keyword {
+		case "type":
+			schema.Types, err = compileTypes(field, at)
+		case "enum":
+			enum, ok := field.([]interface{})
+			if !ok || len(enum) == 0 {
+				err = invalid(at, "must be a non empty array")
+			}
+			schema.Enum = enum
+		case "properties":
+			schema.Properties, err = compileProperties(field, at)
+		case "required":
+			schema.Required, err = compileStrings(field, at)
+		case "additionalProperties":
+			allowed, ok := field.(bool)
+			if !ok {
+				err = invalid(at, "must be a boolean")
+			}
+			schema.AdditionalProperties = &allowed
+		case "items":
+			schema.Items, err = compile(field, at)
+		case "minimum":
+			schema.Minimum, err = compileNumber(field, at)
+		case "maximum":
+			schema.Maximum, err = compileNumber(field, at)
+		case "minLength":
+			schema.MinLength, err = compileCount(field, at)
+		case "maxLength":
+			schema.MaxLength, err = compileCount(field, at)
+		case "minItems":
+			schema.MinItems, err = compileCount(field, at)
+		case "maxItems":
+			schema.MaxItems, err = compileCount(field, at)
+		case "pattern":
+			pattern, ok := field.(string)
+			if !ok {
+				err = invalid(at, "must be a string")
+				break
+			}
+			if schema.Pattern, err = regexp.Compile(pattern); err != nil {
+				err = invalid(at, patternError(pattern, err))
+			}
+		default:
+			if !annotations[keyword] {
+				err = invalid(at, "unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+func compileTypes(value interface{}, path string) ([]string, error) {
+	if name, ok := value.(string); ok {
+		value = []interface{}{name}
+	}
+	types, err := compileStrings(value, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range types {
+		switch name {
+		case TypeNull, TypeBoolean, TypeObject, TypeArray, TypeNumber, TypeInteger, TypeString:
+		default:
+			return nil, invalid(path, "unknown type "+name)
+		}
+	}
+	return types, nil
+}
+
+func compileProperties(value interface{}, path string) (map[string]*Schema, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, invalid(path, "must be an object")
+	}
+	properties := make(map[string]*Schema)
+	for name, field := range object {
+		property, err := compile(field, path+"."+name)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = property
+	}
+	return properties, nil
+}
+
+func compileStrings(value interface{}, path string) ([]string, error) {
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, invalid(path, "must be an array of strings")
+	}
+	var strings []string
+	for _, item := range array {
+		name, ok := item.(string)
+		if !ok {
+			return nil, invalid(path, "must be an array of strings")
+		}
+		strings = append(strings, name)
+	}
+	return strings, nil
+}
+
+func compileNumber(value interface{}, path string) (*big.Float, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, invalid(path, "must be a number")
+	}
+	float, _, err := big.ParseFloat(number.String(), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return nil, invalid(path, err.Error())
+	}
+	return float, nil
+}
+
+func compileCount(value interface{}, path string) (*int, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, invalid(path, "must be a non negative integer")
+	}
+	count, err := strconv.Atoi(number.String())
+	if err != nil || count < 0 {
+		return nil, invalid(path, "must be a non negative integer")
+	}
+	return &count, nil
+}
+
+// Names the ECMA 262 features RE2 lacks, which the syntax error only reports as invalid
+func patternError(pattern string, err error) string {
+	var syntaxErr *syntax.Error
+	if !errors.As(err, &syntaxErr) {
+		return err.Error()
+	}
+	for _, lookaround := range []string{"(?=", "(?!", "(?<=", "(?<!"} {
+		if strings.Contains(pattern, lookaround) { // older versions of Go report (?<= as (?<
+			return "unsupported lookaround " + lookaround + ", patterns use the RE2 syntax"
+		}
+	}
+	if syntaxErr.Code == syntax.ErrInvalidEscape && len(syntaxErr.Expr) == 2 && strings.ContainsRune("123456789k", rune(syntaxErr.Expr[1])) {
+		return "unsupported backreference " + syntaxErr.Expr + ", patterns use the RE2 syntax"
+	}
+	return err.Error()
+}
+
+func invalid(path, detail string) error {
+	return fmt.Errorf("%w: %s %s", ErrInvalid, path, detail)
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// VALIDATE
+
+func (schema *Schema) validate(value interface{}, path string) error {
+	if len(schema.Types) > 0 && !schema.hasType(value) {
+		return mismatch(path, "expected "+joinTypes(schema.Types))
+	}
+	if len(schema.Enum) > 0 && !schema.inEnum(value) {
+		return mismatch(path, "not one of the enum values")
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return schema.validateObject(value, path)
+	case []interface{}:
+		return schema.validateArray(value, path)
+	case json.Number:
+		return schema.validateNumber(value, path)
+	case string:
+		return schema.validateString(value, path)
+	}
+	return nil
+}
+
+func (schema *Schema) validateObject(object map[string]interface{}, path string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return mismatch(path, "missing property "+name)
+		}
+	}
+	for _, name := range keys(object) {
+		property, ok := schema.Properties[name]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				return mismatch(path, "unexpected property "+name)
+			}
+			continue
+		}
+		if err := property.validate(object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) validateArray(array []interface{}, path string) error {
+	if schema.MinItems != nil && len(array) < *schema.MinItems {
+		return mismatch(path, "fewer than "+strconv.Itoa(*schema.MinItems)+" items")
+	}
+	if schema.MaxItems != nil && len(array) > *schema.MaxItems {
+		return mismatch(path, "more than "+strconv.Itoa(*schema.MaxItems)+" items")
+	}
+	if schema.Items == nil {
+		return nil
+	}
+	for i, item := range array {
+		if err := schema.Items.validate(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (schema *Schema) validateNumber(number json.Number, path string) error {
+	if schema.Minimum == nil && schema.Maximum == nil {
+		return nil
+	}
+	float, _, err := big.ParseFloat(number.String(), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return mismatch(path, err.Error())
+	}
+	if schema.Minimum != nil && float.Cmp(schema.Minimum) < 0 {
+		return mismatch(path, "less than "+schema.Minimum.String())
+	}
+	if schema.Maximum != nil && float.Cmp(schema.Maximum) > 0 {
+		return mismatch(path, "greater than "+schema.Maximum.String())
+	}
+	return nil
+}
+
+func (schema *Schema) validateString(value string, path string) error {
+	length := utf8.RuneCountInString(value)
+	if schema.MinLength != nil && length < *schema.MinLength {
+		return mismatch(path, "shorter than "+strconv.Itoa(*schema.MinLength))
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		return mismatch(path, "longer than "+strconv.Itoa(*schema.MaxLength))
+	}
+	if schema.Pattern != nil && !schema.Pattern.MatchString(value) {
+		return mismatch(path, "doesn't match "+schema.Pattern.String())
+	}
+	return nil
+}
+
+func (schema *Schema) hasType(value interface{}) bool {
+	for _, name := range schema.Types {
+		if typeOf(value) == name || (name == TypeNumber && typeOf(value) == TypeInteger) {
+			return true
+		}
+	}
+	return false
+}
+
+func (schema *Schema) inEnum(value interface{}) bool {
+	encoded, _ := json.Marshal(value)
+	for _, item := range schema.Enum {
+		option, _ := json.Marshal(item)
+		if bytes.Equal(encoded, option) { // maps are encoded with sorted keys
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case json.Number:
+		float, _, err := big.ParseFloat(value.String(), 10, 256, big.ToNearestEven)
+		if err == nil && float.IsInt() {
+			return TypeInteger
+		}
+		return TypeNumber
+	default:
+		return TypeString
+	}
+}
+
+func joinTypes(types []string) string {
+	joined := types[0]
+	for _, name := range types[1:] {
+		joined += " or " + name
+	}
+	return joined
+}
+
+func mismatch(path, detail string) error {
+	return fmt.Errorf("%w: %s %s", ErrMismatch, path, detail)
+}
+
+// Object keys in order, so the first error reported is always the same
+func keys(object map[string]interface{}) []string {
+	var names []string
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
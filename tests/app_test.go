@@ -2,17 +2,25 @@ package tests
 
 import (
 	"dbc-node/app"
+	"dbc-node/crypto"
 	"dbc-node/messages"
 	"dbc-node/modules"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	dbm "github.com/tendermint/tm-db"
+	"math"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestApp(t *testing.T) {
-	dbc := app.NewDataBlockChain(genUsers, genValidators)
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
 	_ = dbc.Info(mockRequestInfo())
 
 	checkTx(t, dbc, messages.TxAddData, 1)
@@ -23,11 +31,416 @@ func TestApp(t *testing.T) {
 	checkTx(t, dbc, messages.TxStake, 1)
 }
 
+func TestAppRestart(t *testing.T) {
+	db := dbm.NewMemDB()
+	dbc, _ := app.NewDataBlockChain(db)
+	_ = dbc.InitChain(mockRequestInitChain())
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 0))
+	expiring := mockDescription(1)
+	expiring.ExpiryHeight = 3
+	expiring.Signature = crypto.Sign(requirerPrivKey, expiring.SignBytes(testChainID))
+	_ = dbc.DeliverTx(mockTransactionTx(messages.Transaction{TxType: messages.TxAddData, Description: expiring}))
+	_ = dbc.Commit()
+	_ = dbc.BeginBlock(types.RequestBeginBlock{Header: types.Header{Height: 2, Time: time.Unix(1000, 0)}})
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxTransfer, 0))
+	commit := dbc.Commit()
+
+	restarted, err := app.NewDataBlockChain(db)
+	if err != nil {
+		t.Fatalf("Failed loading persisted state: %v", err)
+	}
+	info := restarted.Info(mockRequestInfo())
+	if info.LastBlockHeight != 2 {
+		t.Errorf("Persisted height not restored")
+	}
+	checkHash(info.LastBlockAppHash, commit.Data, "Persisted app", t)
+	if len(restarted.New.Dataset.DataList) != 2 {
+		t.Errorf("Persisted state not restored")
+	}
+	if len(restarted.New.Balance.Transfers) != 0 {
+		t.Errorf("Transfer history persisted")
+	}
+	if restarted.Time != 1000 {
+		t.Errorf("Persisted block time not restored")
+	}
+	if !dbc.Check.Dataset.DataList[1].Closed || !restarted.Check.Dataset.DataList[1].Closed {
+		t.Errorf("Check state not set at the next block after restart")
+	}
+	if restarted.New.Balance.Users[hex.EncodeToString(acceptorPubKey)] != dbc.New.Balance.Users[hex.EncodeToString(acceptorPubKey)] {
+		t.Errorf("Persisted balance not restored")
+	}
+}
+
+func TestPruneStates(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	for height := 0; height < app.KeepHeights+2; height++ {
+		_ = dbc.Commit()
+	}
+	query := mockRequestQuery(messages.PathStakes, false)
+	for height, code := range map[int64]uint32{
+		1:                   modules.ErrNotFound.Code,
+		2:                   modules.ErrNotFound.Code,
+		3:                   0,
+		dbc.Height - 1:      0,
+		dbc.Height + 1:      modules.ErrInvalidArgument.Code,
+		app.KeepHeights * 2: modules.ErrInvalidArgument.Code,
+	} {
+		query.Height = height
+		if response := dbc.Query(query); response.Code != code {
+			t.Errorf("Invalid query of the state at height %d: %s", height, response.Log)
+		}
+	}
+}
+
+func TestCheckTx(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+
+	if response := dbc.CheckTx(mockRequestCheckTx([]byte("{not a transaction"))); response.Code == 0 {
+		t.Errorf("Malformed transaction accepted")
+	}
+	unknown, _ := json.Marshal(messages.Transaction{TxType: "TxUnknown"})
+	if response := dbc.CheckTx(mockRequestCheckTx(unknown)); response.Code == 0 {
+		t.Errorf("Unknown transaction type accepted")
+	}
+	transfer := mockTransfer(validatorPubKey, validatorPrivKey, acceptorPubKey, modules.ToSats(2), 0)
+	transfer.Signature = crypto.Sign(providerPrivKey, transfer.SignBytes(testChainID))
+	forged, _ := json.Marshal(messages.Transaction{TxType: messages.TxTransfer, Transfer: transfer})
+	if response := dbc.CheckTx(mockRequestCheckTx(forged)); response.Code == 0 {
+		t.Errorf("Transaction with invalid signature accepted")
+	}
+
+	// validator has 5 DBCC, the third pending transfer of 2 DBCC can't be paid
+	for nonce := int64(0); nonce < 2; nonce++ {
+		if response := dbc.CheckTx(types.RequestCheckTx{Tx: mockRequestDeliverTx(messages.TxTransfer, nonce).Tx}); response.Code != 0 {
+			t.Errorf("Valid transaction rejected: " + response.Log)
+		}
+	}
+	if response := dbc.CheckTx(types.RequestCheckTx{Tx: mockRequestDeliverTx(messages.TxTransfer, 2).Tx}); response.Code == 0 {
+		t.Errorf("Pending transfers not counted against balance")
+	}
+	if len(dbc.New.Balance.Transfers) != 0 {
+		t.Errorf("Checked transaction applied to delivered state")
+	}
+	_ = dbc.Commit()
+	if response := dbc.CheckTx(types.RequestCheckTx{Tx: mockRequestDeliverTx(messages.TxTransfer, 0).Tx}); response.Code != 0 {
+		t.Errorf("Check state not reset at commit")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	transfer := mockRequestDeliverTx(messages.TxTransfer, 0)
+	if response := dbc.DeliverTx(transfer); response.Code != 0 {
+		t.Errorf("Valid transaction rejected: " + response.Log)
+	}
+	if response := dbc.DeliverTx(transfer); response.Code == 0 {
+		t.Errorf("Replayed transaction delivered")
+	}
+	if response := dbc.DeliverTx(mockRequestDeliverTx(messages.TxTransfer, 2)); response.Code == 0 {
+		t.Errorf("Transaction with future nonce delivered")
+	}
+	if len(dbc.New.Balance.Transfers) != 1 || dbc.New.Balance.NextNonce(validatorPubKey) != 1 {
+		t.Errorf("Rejected transactions changed the state")
+	}
+}
+
+func TestUnstakeFee(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	if response := dbc.DeliverTx(mockRequestDeliverTx(messages.TxStake, 0)); response.Code != 0 {
+		t.Fatalf("Failed to stake: %s", response.Log)
+	}
+	provider := hex.EncodeToString(providerPubKey)
+	validator := hex.EncodeToString(stakePubKey)
+	users, validators := dbc.New.Balance.Users[provider], dbc.New.Balance.Validators[validator]
+
+	unstake := mockStake(providerPubKey, providerPrivKey, stakePubKey, stakePrivKey, -modules.ToSats(1), 0)
+	if response := dbc.DeliverTx(mockTransactionTx(messages.Transaction{TxType: messages.TxStake, Stake: unstake})); response.Code != 0 {
+		t.Fatalf("Failed to unstake: %s", response.Log)
+	}
+	if dbc.New.Balance.Users[provider] != users+modules.ToSats(1) || dbc.New.Balance.NextNonce(providerPubKey) != 1 {
+		t.Errorf("Fee of the unstake charged to the user")
+	}
+	if dbc.New.Balance.Validators[validator] != validators-modules.ToSats(1)-modules.TxFee || dbc.New.Balance.NextNonce(stakePubKey) != 1 {
+		t.Errorf("Fee of the unstake not charged to the validator")
+	}
+}
+
+func TestQueryProof(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 0))
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxTransfer, 0))
+	commit := dbc.Commit()
+
+	paths := []string{
+		messages.Path(messages.PathData, "0"),
+		messages.Path(messages.PathBalance, hex.EncodeToString(validatorPubKey)),
+		messages.Path(messages.PathStake, hex.EncodeToString(stakePubKey)),
+	}
+	for _, path := range paths {
+		response := dbc.Query(mockRequestQuery(path, true))
+		if response.Proof == nil || response.Height != 1 {
+			t.Errorf(path + ": proof not returned")
+			continue
+		}
+		keyPath := merkle.KeyPath{}.AppendKey(response.Key, merkle.KeyEncodingURL).String()
+		if err := merkle.DefaultProofRuntime().VerifyValue(response.Proof, commit.Data, keyPath, response.Value); err != nil {
+			t.Errorf(path + ": invalid proof: " + err.Error())
+		}
+		if err := merkle.DefaultProofRuntime().VerifyValue(response.Proof, commit.Data, keyPath, []byte("{}")); err == nil {
+			t.Errorf(path + ": proof verified for a different value")
+		}
+	}
+	var account modules.Account
+	response := dbc.Query(mockRequestQuery(messages.Path(messages.PathBalance, hex.EncodeToString(validatorPubKey)), false))
+	_ = json.Unmarshal(response.Value, &account)
+	if account.Nonce != 1 || account.Balance != genUsers[hex.EncodeToString(validatorPubKey)]-modules.ToSats(2)-modules.TxFee {
+		t.Errorf("Invalid account returned")
+	}
+}
+
+func TestQueryRoutes(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 0))
+	_ = dbc.Commit()
+
+	invalid := map[string]string{
+		"/unknown":                   "unknown path",
+		"/dataset/data":              "incomplete path",
+		"/dataset/data/zero":         "invalid index",
+		"/dataset/data/-1":           "negative index",
+		"/dataset/data/1":            "index out of range",
+		"/dataset/data/0/versions/0": "version out of range",
+		"/balance/not-a-key":         "invalid public key",
+		messages.Path(messages.PathStake, hex.EncodeToString(providerPubKey)): "unknown validator",
+	}
+	for path, descriptor := range invalid {
+		if response := dbc.Query(mockRequestQuery(path, false)); response.Code == 0 {
+			t.Errorf(descriptor + ": query succeeded")
+		}
+	}
+	var description modules.Description
+	response := dbc.Query(mockRequestQuery(messages.Path(messages.PathDescription, "0"), false))
+	if err := json.Unmarshal(response.Value, &description); err != nil || response.Code != 0 {
+		t.Errorf("Failed to query description")
+	}
+	compareDescription(&description, dbc.Committed.Dataset.DataList[0].Description, t)
+}
+
+func TestQueryIndex(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 0))
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddValidation, 0))
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddPayload, 0))
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 1))
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 2))
+	_ = dbc.Commit()
+
+	requirer := hex.EncodeToString(requirerPubKey)
+	acceptor := hex.EncodeToString(acceptorPubKey)
+	pages := map[string][]messages.IndexEntry{
+		messages.Path(messages.PathRoleIndex, messages.RoleRequirer, requirer):                                        {indexEntry(0, -1), indexEntry(1, -1), indexEntry(2, -1)},
+		messages.Path(messages.PathRoleIndex, messages.RoleProvider, hex.EncodeToString(providerPubKey)):              {indexEntry(0, 0)},
+		messages.Path(messages.PathRoleIndex, messages.RoleValidator, hex.EncodeToString(validatorPubKey)):            {indexEntry(0, -1), indexEntry(0, 0), indexEntry(1, -1), indexEntry(2, -1)},
+		messages.Path(messages.PathStateIndex, messages.StateAwaitingAcceptance):                                      {indexEntry(0, 0)},
+		messages.Path(messages.PathStateIndex, messages.StateClosed):                                                  {},
+		messages.Path(messages.PathRoleStateIndex, messages.RoleAcceptor, acceptor, messages.StateAwaitingAcceptance): {indexEntry(0, 0)},
+		messages.Path(messages.PathRoleIndex, messages.RoleAcceptor, requirer):                                        {},
+		messages.Paged(messages.Path(messages.PathStateIndex, messages.StateAwaitingValidation), 1, 1):                {indexEntry(1, -1)},
+	}
+	for path, entries := range pages {
+		var items []messages.IndexEntry
+		page := messages.Page{Items: &items}
+		response := dbc.Query(mockRequestQuery(path, false))
+		if err := json.Unmarshal(response.Value, &page); err != nil || response.Code != 0 {
+			t.Errorf("Failed to query %s: %s", path, response.Log)
+			continue
+		}
+		if !reflect.DeepEqual(items, entries) {
+			t.Errorf("Invalid entries of %s: %v", path, items)
+		}
+	}
+	var items []messages.IndexEntry
+	page := messages.Page{Items: &items}
+	response := dbc.Query(mockRequestQuery(messages.Paged(messages.Path(messages.PathRoleIndex, messages.RoleRequirer, requirer), 2, 10), false))
+	if _ = json.Unmarshal(response.Value, &page); page.Total != 3 || page.Offset != 2 || page.Limit != 10 || len(items) != 1 {
+		t.Errorf("Invalid page: %+v", page)
+	}
+
+	invalid := map[string]string{
+		messages.Path(messages.PathRoleIndex, "owner", requirer):                                             "unknown role",
+		messages.Path(messages.PathStateIndex, "pending"):                                                    "unknown state",
+		messages.Paged(messages.Path(messages.PathStateIndex, messages.StateClosed), -1, 10):                 "negative offset",
+		messages.Paged(messages.Path(messages.PathStateIndex, messages.StateClosed), 0, 0):                   "zero limit",
+		messages.Paged(messages.Path(messages.PathStateIndex, messages.StateClosed), 0, messages.MaxLimit+1): "limit over max",
+	}
+	for path, descriptor := range invalid {
+		if response := dbc.Query(mockRequestQuery(path, false)); response.Code != modules.ErrInvalidArgument.Code {
+			t.Errorf("%s: query succeeded: %d %s", descriptor, response.Code, response.Log)
+		}
+	}
+}
+
+func TestQueryIndexProviders(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 0))
+	for versionIndex := 0; versionIndex < 2; versionIndex++ {
+		validation := mockValidation(zpks[versionIndex], 0, int64(versionIndex))
+		_ = dbc.DeliverTx(mockTransactionTx(messages.Transaction{TxType: messages.TxAddValidation, Validation: validation, DataIndex: 0}))
+	}
+	payload := mockPayload(zpks[0], 0, 0, 0)
+	second := mockPayload(zpks[1], 0, 1, 0)
+	second.ProviderAddr = acceptorPubKey
+	second.Signature = crypto.Sign(acceptorPrivKey, second.SignBytes(testChainID))
+	for versionIndex, payload := range []*modules.Payload{payload, second} {
+		transaction := messages.Transaction{TxType: messages.TxAddPayload, Payload: payload, DataIndex: 0, VersionIndex: versionIndex}
+		if response := dbc.DeliverTx(mockTransactionTx(transaction)); response.Code != 0 {
+			t.Fatalf("Failed to add payload: %s", response.Log)
+		}
+	}
+	_ = dbc.Commit()
+
+	pages := map[string][]messages.IndexEntry{
+		messages.Path(messages.PathRoleStateIndex, messages.RoleProvider, hex.EncodeToString(providerPubKey), messages.StateAwaitingAcceptance):   {indexEntry(0, 0)},
+		messages.Path(messages.PathRoleStateIndex, messages.RoleProvider, hex.EncodeToString(acceptorPubKey), messages.StateAwaitingAcceptance):   {indexEntry(0, 1)},
+		messages.Path(messages.PathRoleStateIndex, messages.RoleProvider, hex.EncodeToString(providerPubKey), messages.StateAwaitingValidation):   {indexEntry(0, -1)},
+		messages.Path(messages.PathRoleStateIndex, messages.RoleValidator, hex.EncodeToString(validatorPubKey), messages.StateAwaitingAcceptance): {indexEntry(0, 0), indexEntry(0, 1)},
+		messages.Path(messages.PathRoleStateIndex, messages.RoleRequirer, hex.EncodeToString(requirerPubKey), messages.StateAwaitingAcceptance):   {indexEntry(0, 0), indexEntry(0, 1)},
+	}
+	for path, entries := range pages {
+		var items []messages.IndexEntry
+		page := messages.Page{Items: &items}
+		response := dbc.Query(mockRequestQuery(path, false))
+		if err := json.Unmarshal(response.Value, &page); err != nil || response.Code != 0 {
+			t.Errorf("Failed to query %s: %s", path, response.Log)
+			continue
+		}
+		if !reflect.DeepEqual(items, entries) {
+			t.Errorf("Invalid entries of %s: %v", path, items)
+		}
+	}
+}
+
+func TestQueryPages(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	for nonce := int64(0); nonce < 3; nonce++ {
+		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, nonce))
+	}
+	_ = dbc.Commit()
+
+	var data []modules.Data
+	response := dbc.Query(mockRequestQuery(messages.Paged(messages.PathDataset, 1, 1), false))
+	if err := json.Unmarshal(response.Value, &messages.Page{Items: &data}); err != nil || len(data) != 1 {
+		t.Fatalf("Failed to query dataset page: %s", response.Log)
+	}
+	compareDescription(data[0].Description, dbc.Committed.Dataset.DataList[1].Description, t)
+
+	var accounts []messages.AccountEntry
+	for offset := 0; offset < len(genUsers); offset += 2 {
+		var items []messages.AccountEntry
+		page := messages.Page{Items: &items}
+		response := dbc.Query(mockRequestQuery(messages.Paged(messages.PathBalances, offset, 2), false))
+		if err := json.Unmarshal(response.Value, &page); err != nil || page.Total != len(genUsers) {
+			t.Fatalf("Failed to query balances page: %s", response.Log)
+		}
+		accounts = append(accounts, items...)
+	}
+	if len(accounts) != len(genUsers) {
+		t.Fatalf("Balances pages don't cover every account: %d", len(accounts))
+	}
+	for i, account := range accounts {
+		if i > 0 && accounts[i-1].User >= account.User {
+			t.Errorf("Balances not in order")
+		}
+		if account.Balance != dbc.Committed.Balance.Users[account.User] {
+			t.Errorf("Invalid balance of %s", account.User)
+		}
+	}
+
+	validators := len(dbc.Committed.Balance.Validators)
+	var stakes []messages.StakeEntry
+	page := messages.Page{Items: &stakes}
+	response = dbc.Query(mockRequestQuery(messages.PathStakes, false))
+	if err := json.Unmarshal(response.Value, &page); err != nil || page.Total != validators || len(stakes) != validators ||
+		page.Limit != messages.DefaultLimit {
+		t.Errorf("Failed to query stakes: %s", response.Log)
+	}
+	response = dbc.Query(mockRequestQuery(messages.Paged(messages.PathStakes, 10, 10), false))
+	if err := json.Unmarshal(response.Value, &page); err != nil || len(stakes) != 0 || page.Total != validators {
+		t.Errorf("Invalid page past the end of the list")
+	}
+	response = dbc.Query(mockRequestQuery(messages.Paged(messages.PathStakes, math.MaxInt64, 10), false))
+	if err := json.Unmarshal(response.Value, &page); err != nil || len(stakes) != 0 || page.Total != validators {
+		t.Errorf("Invalid page at the largest offset: %s", response.Log)
+	}
+}
+
+func indexEntry(dataIndex, versionIndex int) messages.IndexEntry {
+	return messages.IndexEntry{Data: dataIndex, Version: versionIndex}
+}
+
+func TestErrorCodes(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	response := dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddValidation, 0))
+	if response.Code != modules.ErrUnknownData.Code || response.Codespace != modules.Codespace {
+		t.Errorf("Invalid error code for unknown data: %d %s", response.Code, response.Log)
+	}
+	response = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddValidation, 0))
+	if response.Code != modules.ErrInvalidNonce.Code {
+		t.Errorf("Invalid error code for stale nonce: %d %s", response.Code, response.Log)
+	}
+	unknown, _ := json.Marshal(messages.Transaction{TxType: "TxUnknown"})
+	if response := dbc.CheckTx(mockRequestCheckTx(unknown)); response.Code != modules.ErrUnknownTxType.Code {
+		t.Errorf("Invalid error code for unknown transaction type: %d %s", response.Code, response.Log)
+	}
+	if response := dbc.Query(mockRequestQuery("/unknown", false)); response.Code != modules.ErrUnknownPath.Code {
+		t.Errorf("Invalid error code for unknown path: %d %s", response.Code, response.Log)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	response := dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, 0))
+	if len(response.Events) != 1 || response.Events[0].Type != modules.EventAddData {
+		t.Fatalf("Missing add data event: %v", response.Events)
+	}
+	checkAttribute(response.Events[0], modules.AttributeDataIndex, "0", t)
+	checkAttribute(response.Events[0], modules.AttributeRequirer, hex.EncodeToString(requirerPubKey), t)
+	checkAttribute(response.Events[0], modules.AttributeProviderAmount, strconv.FormatInt(mockDescription(0).ProviderAmount, 10), t)
+
+	response = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddPayload, 0))
+	if response.Code == 0 || len(response.Events) != 0 {
+		t.Errorf("Failed transaction emitted events: %v", response.Events)
+	}
+}
+
+func checkAttribute(event types.Event, key, value string, t *testing.T) {
+	for _, attribute := range event.Attributes {
+		if string(attribute.Key) == key {
+			if string(attribute.Value) != value {
+				t.Errorf("Invalid %s attribute of %s event: %s", key, event.Type, attribute.Value)
+			}
+			return
+		}
+	}
+	t.Errorf("Missing %s attribute in %s event", key, event.Type)
+}
+
 func checkTx(t *testing.T, dbc *app.DataBlockChain, txType messages.TransactionType, txCount int) {
 	switch txType {
 
 	case messages.TxAddData:
-		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData))
+		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxAddData, int64(txCount-1)))
 		if len(dbc.New.Dataset.DataList) != txCount {
 			t.Errorf("Transaction not added")
 		}
@@ -35,10 +448,10 @@ func checkTx(t *testing.T, dbc *app.DataBlockChain, txType messages.TransactionT
 		if len(dbc.New.Dataset.DataList) != txCount {
 			t.Errorf("Transaction not retained")
 		}
-		_ = dbc.Query(mockRequestQuery())
+		_ = dbc.Query(mockRequestQuery(messages.PathDataset, false))
 
 	case messages.TxTransfer:
-		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxTransfer))
+		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxTransfer, int64(txCount-1)))
 		if len(dbc.New.Balance.Transfers) != txCount {
 			t.Errorf("Transaction not added")
 		}
@@ -62,10 +475,10 @@ func checkTx(t *testing.T, dbc *app.DataBlockChain, txType messages.TransactionT
 			(genUsers[hex.EncodeToString(acceptorPubKey)] + modules.ToSats(2*int64(txCount))) {
 			t.Errorf("Transfer amount not added")
 		}
-		_ = dbc.Query(mockRequestQuery())
+		_ = dbc.Query(mockRequestQuery(messages.PathDataset, false))
 
 	case messages.TxStake:
-		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxStake))
+		_ = dbc.DeliverTx(mockRequestDeliverTx(messages.TxStake, int64(txCount-1)))
 		if len(dbc.New.Balance.Stakes) != txCount {
 			t.Errorf("Transaction not added")
 		}
@@ -100,7 +513,26 @@ func mockRequestInfo() types.RequestInfo {
 	}
 }
 
-func mockRequestDeliverTx(txType messages.TransactionType) types.RequestDeliverTx {
+func mockRequestInitChain() types.RequestInitChain {
+	appState, _ := json.Marshal(mockGenesisState())
+	return types.RequestInitChain{
+		ChainId:       testChainID,
+		AppStateBytes: appState,
+	}
+}
+
+func mockGenesisState() *app.GenesisState {
+	genesis := &app.GenesisState{}
+	for user, balance := range genUsers {
+		genesis.Accounts = append(genesis.Accounts, app.GenesisAccount{PubKey: user, Balance: balance})
+	}
+	for validator, stake := range genValidators {
+		genesis.Stakes = append(genesis.Stakes, app.GenesisStake{Validator: validator, Amount: stake})
+	}
+	return genesis
+}
+
+func mockRequestDeliverTx(txType messages.TransactionType, nonce int64) types.RequestDeliverTx {
 	transaction := messages.Transaction{
 		TxType:       txType,
 		DataIndex:    0,
@@ -108,24 +540,30 @@ func mockRequestDeliverTx(txType messages.TransactionType) types.RequestDeliverT
 	}
 	switch txType {
 	case messages.TxAddData:
-		description := mockDescription()
+		description := mockDescription(nonce)
 		transaction.Description = description
 	case messages.TxAddValidation:
-		validation := mockValidation(zpks[0])
+		validation := mockValidation(zpks[0], 0, nonce)
 		transaction.Validation = validation
 	case messages.TxAddPayload:
-		payload := mockPayload(zpks[0])
+		payload := mockPayload(zpks[0], 0, 0, nonce)
 		transaction.Payload = payload
 	case messages.TxAcceptPayload:
-		acceptedPayload := mockAcceptedPayload()
+		acceptedPayload := mockAcceptedPayload(0, 0, nonce)
 		transaction.AcceptedPayload = acceptedPayload
+	case messages.TxRegisterSchema:
+		transaction.Schema = mockSchema("invoice", invoiceSchema)
 	case messages.TxTransfer:
-		transfer := mockTransfer(validatorPubKey, validatorPrivKey, acceptorPubKey, modules.ToSats(2))
+		transfer := mockTransfer(validatorPubKey, validatorPrivKey, acceptorPubKey, modules.ToSats(2), nonce)
 		transaction.Transfer = transfer
 	case messages.TxStake:
-		stake := mockStake(providerPubKey, providerPrivKey, stakePubKey, stakePrivKey, modules.ToSats(1))
+		stake := mockStake(providerPubKey, providerPrivKey, stakePubKey, stakePrivKey, modules.ToSats(1), nonce)
 		transaction.Stake = stake
 	}
+	return mockTransactionTx(transaction)
+}
+
+func mockTransactionTx(transaction messages.Transaction) types.RequestDeliverTx {
 	tx, _ := json.Marshal(transaction)
 	encodedTx := make([]byte, base64.StdEncoding.EncodedLen(len(tx)))
 	base64.StdEncoding.Encode(encodedTx, tx)
@@ -134,19 +572,18 @@ func mockRequestDeliverTx(txType messages.TransactionType) types.RequestDeliverT
 	}
 }
 
-func mockRequestQuery() types.RequestQuery {
-	query := messages.Query{
-		QrType:       messages.QueryDataset,
-		DataIndex:    0,
-		VersionIndex: 0,
+func mockRequestCheckTx(tx []byte) types.RequestCheckTx {
+	encodedTx := make([]byte, base64.StdEncoding.EncodedLen(len(tx)))
+	base64.StdEncoding.Encode(encodedTx, tx)
+	return types.RequestCheckTx{
+		Tx: encodedTx,
 	}
-	data, _ := json.Marshal(query)
-	encodedData := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
-	base64.StdEncoding.Encode(encodedData, data)
+}
+
+func mockRequestQuery(path string, prove bool) types.RequestQuery {
 	return types.RequestQuery{
-		Data:   encodedData,
-		Path:   "",
+		Path:   path,
 		Height: 0,
-		Prove:  false,
+		Prove:  prove,
 	}
 }
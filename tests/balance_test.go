@@ -15,6 +15,7 @@ import (
 
 func initBalance() *modules.Balance {
 	return modules.NewBalance(&modules.Balance{
+		ChainID:    testChainID,
 		Users:      initialUsers,
 		Validators: initialValidators,
 	})
@@ -31,11 +32,6 @@ func TestBalance(t *testing.T) {
 	if balance.Transfers != nil && balance.Stakes != nil && balance.Rewards != nil && balance.Fees != nil {
 		t.Errorf("Failed initializing balance transactions list")
 	}
-	validHash := sha256.Sum256(nil)
-	hash := balance.Hash()
-	if bytes.Compare(hash, validHash[:]) != 0 {
-		t.Errorf("Failed initializing balance hash")
-	}
 }
 
 func TestAddTransfer(t *testing.T) {
@@ -44,7 +40,7 @@ func TestAddTransfer(t *testing.T) {
 	senderKey := acceptorPrivKey
 	receiver := requirerPubKey
 	amount := modules.ToSats(2)
-	transfer := mockTransfer(sender, senderKey, receiver, amount)
+	transfer := mockTransfer(sender, senderKey, receiver, amount, 0)
 	balance.AddTransfer(transfer)
 	if len(balance.Transfers) != 1 {
 		t.Errorf("Failed to register transfer")
@@ -55,23 +51,50 @@ func TestAddTransfer(t *testing.T) {
 	if balance.Users[hex.EncodeToString(receiver)] != (initialUsers[hex.EncodeToString(receiver)] + amount) {
 		t.Errorf("Failder to add transfer ammount")
 	}
-	validHash := sha256.Sum256(transfer.Hash())
-	if bytes.Compare(balance.Hash(), validHash[:]) != 0 {
-		t.Errorf("Incorrect hash after transfer")
+	if !reflect.DeepEqual(balance.Transfers, []*modules.Transfer{transfer}) {
+		t.Errorf("Incorrect transfers after transfer")
 	}
 }
 
-func mockTransfer(sender, senderKey, receiver []byte, amount int64) *modules.Transfer {
-	time := time.Now().Unix()
-	id := append(sender, receiver...)
-	id = append(id, strconv.FormatInt(amount, 10)...)
-	id = append(id, strconv.FormatInt(time, 10)...)
-	return &modules.Transfer{
-		Sender:    sender,
-		Receiver:  receiver,
-		Amount:    amount,
-		Time:      time,
-		Signature: crypto.Sign(senderKey, id),
+func mockTransfer(sender, senderKey, receiver []byte, amount, nonce int64) *modules.Transfer {
+	transfer := &modules.Transfer{
+		Sender:   sender,
+		Receiver: receiver,
+		Amount:   amount,
+		Time:     time.Now().Unix(),
+		Nonce:    nonce,
+	}
+	transfer.Signature = crypto.Sign(senderKey, transfer.SignBytes(testChainID))
+	return transfer
+}
+
+func TestSignBytes(t *testing.T) {
+	balance := initBalance()
+	transfer := mockTransfer(acceptorPubKey, acceptorPrivKey, requirerPubKey, modules.ToSats(2), 0)
+	transfer.Signature = crypto.Sign(acceptorPrivKey, transfer.SignBytes("other-chain"))
+	if err := balance.AddTransfer(transfer); err == nil {
+		t.Errorf("Transfer signed for another chain accepted")
+	}
+	transfer = mockTransfer(acceptorPubKey, acceptorPrivKey, requirerPubKey, modules.ToSats(2), 0)
+	transfer.Amount = modules.ToSats(3)
+	if err := balance.AddTransfer(transfer); err == nil {
+		t.Errorf("Transfer with altered amount accepted")
+	}
+	stake := mockStake(providerPubKey, providerPrivKey, stakePubKey, stakePrivKey, modules.ToSats(1), 0)
+	if bytes.Compare(stake.SignBytes(testChainID), transfer.SignBytes(testChainID)) == 0 {
+		t.Errorf("Sign bytes not bound to transaction type")
+	}
+	if len(balance.Transfers) != 0 {
+		t.Errorf("Invalid transfers registered")
+	}
+	closeData := modules.CloseData{Requirer: []byte{1}, DataIndex: 3, Nonce: 2}
+	rejected := modules.RejectedPayload{Reason: "<b>late</b> & incomplete"}
+	expected := `{"ChainID":"dbc<test>","TxType":"TxCloseData","Message":{"Requirer":"AQ==","DataIndex":3,"Nonce":2,"Signature":null}}`
+	if signBytes := string(closeData.SignBytes("dbc<test>")); signBytes != expected {
+		t.Errorf("Sign bytes not canonical: %s", signBytes)
+	}
+	if !bytes.Contains(rejected.SignBytes(testChainID), []byte(`"<b>late</b> & incomplete"`)) {
+		t.Errorf("Sign bytes escape HTML characters")
 	}
 }
 
@@ -82,7 +105,7 @@ func TestAddStake(t *testing.T) {
 	validator := stakePubKey
 	validatorKey := stakePrivKey
 	stakeAmount := modules.ToSats(3)
-	stake := mockStake(user, userKey, validator, validatorKey, stakeAmount)
+	stake := mockStake(user, userKey, validator, validatorKey, stakeAmount, 0)
 	balance.AddStake(stake)
 	if len(balance.Stakes) != 1 {
 		t.Errorf("Failed to register stake")
@@ -93,12 +116,11 @@ func TestAddStake(t *testing.T) {
 	if balance.Validators[hex.EncodeToString(stakePubKey)] != (initialValidators[hex.EncodeToString(stakePubKey)] + stakeAmount) {
 		t.Errorf("Failed to add stake amount")
 	}
-	validHash := sha256.Sum256(stake.Hash())
-	if bytes.Compare(balance.Hash(), validHash[:]) != 0 {
-		t.Errorf("Incorrect hash after stake")
+	if !reflect.DeepEqual(balance.Stakes, []*modules.Stake{stake}) {
+		t.Errorf("Incorrect stakes after stake")
 	}
 	unstakeAmount := modules.ToSats(-5)
-	unstake := mockStake(user, userKey, validator, validatorKey, unstakeAmount)
+	unstake := mockStake(user, userKey, validator, validatorKey, unstakeAmount, 1)
 	balance.AddStake(unstake)
 	if len(balance.Stakes) != 2 {
 		t.Errorf("Failed to register unstake")
@@ -109,30 +131,25 @@ func TestAddStake(t *testing.T) {
 	if balance.Validators[hex.EncodeToString(stakePubKey)] != (initialValidators[hex.EncodeToString(stakePubKey)] + stakeAmount + unstakeAmount) {
 		t.Errorf("Failed to add unstake amount")
 	}
-	validHash = sha256.Sum256(append(stake.Hash(), unstake.Hash()...))
-	if bytes.Compare(balance.Hash(), validHash[:]) != 0 {
-		t.Errorf("Incorrect hash after stake")
+	if !reflect.DeepEqual(balance.Stakes, []*modules.Stake{stake, unstake}) {
+		t.Errorf("Incorrect stakes after unstake")
 	}
 }
 
-func mockStake(user, userKey, validator, validatorKey []byte, amount int64) *modules.Stake {
-	time := time.Now().Unix()
-	id := append(user, validator...)
-	id = append(id, strconv.FormatInt(amount, 10)...)
-	id = append(id, strconv.FormatInt(time, 10)...)
-	var signature []byte
-	if amount >= 0 {
-		signature = crypto.Sign(userKey, id)
-	} else {
-		signature = crypto.SignED(validatorKey, id)
-	}
-	return &modules.Stake{
+func mockStake(user, userKey, validator, validatorKey []byte, amount, nonce int64) *modules.Stake {
+	stake := &modules.Stake{
 		User:      user,
 		Validator: validator,
 		Amount:    amount,
-		Time:      time,
-		Signature: signature,
+		Time:      time.Now().Unix(),
+		Nonce:     nonce,
+	}
+	if amount >= 0 {
+		stake.Signature = crypto.Sign(userKey, stake.SignBytes(testChainID))
+	} else {
+		stake.Signature = crypto.SignED(validatorKey, stake.SignBytes(testChainID))
 	}
+	return stake
 }
 
 func TestAddReward(t *testing.T) {
@@ -166,19 +183,37 @@ func TestAddReward(t *testing.T) {
 	if rewardConfirmed(balance, reward, rewardIndex, 2) {
 		t.Errorf("Reward confirmed after closing")
 	}
+}
 
-	//validHash := sha256.Sum256(sum)
-	//if bytes.Compare(balance.Hash(), validHash[:]) != 0 {
-	//	t.Errorf("Incorrect hash after reward")
-	//}
+func TestRewardEvents(t *testing.T) {
+	balance := initBalance()
+	_, rewardIndex := balance.AddReward(mockReward())
+	_ = balance.ConfirmReward(mockConfirm(), rewardIndex)
+	_ = balance.CloseReward(rewardIndex)
+	events := balance.Events()
+	if len(events) != 2 || events[0].Type != modules.EventConfirmReward || events[1].Type != modules.EventCloseReward {
+		t.Fatalf("Invalid reward events: %v", events)
+	}
+	checkAttribute(events[0], modules.AttributeProvider, hex.EncodeToString(providerPubKey), t)
+	checkAttribute(events[1], modules.AttributeRefund, strconv.FormatInt(modules.ToSats(20), 10), t)
+	if len(balance.Events()) != 0 {
+		t.Errorf("Events not cleared")
+	}
+	_, rewardIndex = balance.AddReward(mockReward())
+	_, _ = balance.HoldReward(mockConfirm(), rewardIndex, 42)
+	events = balance.Events()
+	if len(events) != 1 || events[0].Type != modules.EventHoldReward {
+		t.Fatalf("Invalid hold reward events: %v", events)
+	}
+	checkAttribute(events[0], modules.AttributeReleaseHeight, "42", t)
 }
 
 func mockReward() modules.Reward {
 	return modules.Reward{
 		Info: &modules.RewardInfo{
 			Requirer:        requirerPubKey,
-			Validator:       validatorPubKey,
-			Acceptor:        acceptorPubKey,
+			Validators:      [][]byte{validatorPubKey},
+			Acceptors:       [][]byte{acceptorPubKey},
 			ValidatorAmount: modules.ToSats(2),
 			ProviderAmount:  modules.ToSats(5),
 			AcceptorAmount:  modules.ToSats(3),
@@ -189,7 +224,9 @@ func mockReward() modules.Reward {
 
 func mockConfirm() *modules.RewardConfirm {
 	return &modules.RewardConfirm{
-		Provider: providerPubKey,
+		Provider:  providerPubKey,
+		Validator: validatorPubKey,
+		Acceptors: [][]byte{acceptorPubKey},
 	}
 }
 
@@ -229,8 +266,31 @@ func TestAddFee(t *testing.T) {
 	if balance.Validators[hex.EncodeToString(stakePubKey)] != (initialValidators[hex.EncodeToString(stakePubKey)] + modules.TxFee) {
 		t.Errorf("Failed to add fee amount")
 	}
-	validHash := sha256.Sum256(fee.Hash())
-	if bytes.Compare(balance.Hash(), validHash[:]) != 0 {
-		t.Errorf("Incorrect hash after stake")
+	if !reflect.DeepEqual(balance.Fees, []*modules.Fee{fee}) {
+		t.Errorf("Incorrect fees after fee")
+	}
+}
+
+func TestFeeNonce(t *testing.T) {
+	balance := initBalance()
+	var stakeKey ed25519.PubKeyEd25519
+	copy(stakeKey[:], stakePubKey)
+	for nonce := int64(0); nonce < 3; nonce++ {
+		hash := sha256.Sum256([]byte("Some transaction bytes " + strconv.FormatInt(nonce, 10)))
+		fee := &modules.Fee{User: requirerPubKey, ValAddr: stakeKey.Address(), TxHash: hash[:], Nonce: nonce}
+		if err := balance.AddFee(fee); err != nil {
+			t.Errorf("Failed to add fee with valid nonce: " + err.Error())
+		}
+	}
+	if balance.NextNonce(requirerPubKey) != 3 {
+		t.Errorf("Failed to increment nonce")
+	}
+	hash := sha256.Sum256([]byte("Some replayed transaction bytes"))
+	replayed := &modules.Fee{User: requirerPubKey, ValAddr: stakeKey.Address(), TxHash: hash[:], Nonce: 1}
+	if err := balance.AddFee(replayed); err == nil {
+		t.Errorf("Fee with stale nonce accepted")
+	}
+	if len(balance.Fees) != 3 || balance.Users[hex.EncodeToString(requirerPubKey)] != initialUsers[hex.EncodeToString(requirerPubKey)]-3*modules.TxFee {
+		t.Errorf("Fee with stale nonce charged")
 	}
 }
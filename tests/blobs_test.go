@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dbc-node/blobs"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestBlobStore(t *testing.T) {
+	content := []byte("Some encrypted content")
+	hash := sha256.Sum256(content)
+	committed := func(contentHash []byte) (int64, bool) {
+		return int64(len(content)), bytes.Equal(contentHash, hash[:])
+	}
+	dir, _ := ioutil.TempDir("", "blobs")
+	defer os.RemoveAll(dir)
+	store, err := blobs.NewStore(dir, committed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	url := server.URL + blobs.Route + hex.EncodeToString(hash[:])
+
+	other := sha256.Sum256([]byte("Some content not committed"))
+	if code := put(t, server.URL+blobs.Route+hex.EncodeToString(other[:]), content); code != http.StatusForbidden {
+		t.Errorf("Content not committed accepted: %d", code)
+	}
+	if code := put(t, url, append(content, '!')); code != http.StatusBadRequest {
+		t.Errorf("Content not matching the hash accepted: %d", code)
+	}
+	if response, _ := http.Get(url); response.StatusCode != http.StatusNotFound {
+		t.Errorf("Content served before upload: %d", response.StatusCode)
+	}
+	if code := put(t, url, content); code != http.StatusCreated {
+		t.Fatalf("Failed to upload content: %d", code)
+	}
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	served, _ := ioutil.ReadAll(response.Body)
+	if !bytes.Equal(served, content) {
+		t.Errorf("Served content doesn't match")
+	}
+
+	var uploads sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		uploads.Add(1)
+		go func() {
+			defer uploads.Done()
+			if err := store.Put(hash[:], content); err != nil {
+				t.Errorf("Concurrent upload failed: %v", err)
+			}
+		}()
+	}
+	uploads.Wait()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Temporary files left in the store: %d files", len(files))
+	}
+}
+
+func put(t *testing.T, url string, content []byte) int {
+	request, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(content))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
@@ -5,7 +5,10 @@ import (
 	"crypto/sha256"
 	"dbc-node/crypto"
 	"dbc-node/modules"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/drhodes/golorem"
+	"math"
 	"reflect"
 	"testing"
 )
@@ -16,9 +19,6 @@ import (
 func TestEmptyDataset(t *testing.T) {
 	dataset := modules.NewDataset(&modules.Dataset{}, initBalance())
 	checkNil(dataset.DataList, "Data list", t)
-	validHash := sha256.Sum256(nil)
-	checkHash(dataset.Hash(), validHash[:], "Dataset hash", t)
-
 }
 
 // ------------------------------------------------------------------------------------------------------------------- //
@@ -31,39 +31,59 @@ func TestAddData(t *testing.T) {
 	checkValidData(dataset, t)
 }
 
+func TestRewardOverflow(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	requirer := hex.EncodeToString(requirerPubKey)
+	for _, change := range []func(*modules.Description){
+		func(description *modules.Description) { description.MaxVersions = math.MaxInt64 / 2 },
+		func(description *modules.Description) { description.AcceptorAmount = modules.SatsSupply },
+		func(description *modules.Description) {
+			description.ValidatorAmount, description.ProviderAmount = math.MaxInt64, math.MaxInt64
+		},
+	} {
+		description := mockDescription(0)
+		change(description)
+		description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+		checkError(dataset.AddData(description), modules.ErrInvalidAmount, t)
+	}
+	reward := mockReward()
+	reward.Info.MaxConfirms = math.MaxInt64
+	if err, _ := balance.AddReward(reward); err == nil || balance.Users[requirer] != initialUsers[requirer] {
+		t.Errorf("Reward with an overflowing escrow added")
+	}
+}
+
 func checkValidData(dataset *modules.Dataset, t *testing.T) {
 	initialLength := len(dataset.DataList)
-	otherDataHash, _ := dataHash(dataset, initialLength)
+	otherData := encodeOtherData(dataset, initialLength)
 
-	description := mockDescription()
+	description := mockDescription(0)
 	dataset.AddData(description)
 	data := dataset.DataList[initialLength]
 
 	checkLength(dataset.DataList, initialLength+1, "Data list", t)
 	checkNil(data.VersionList, "Version list", t)
 	compareDescription(data.Description, description, t)
-	dataHash := sha256.Sum256(description.Hash())
-	checkHash(data.Hash(), dataHash[:], "Data", t)
-	datasetHash := sha256.Sum256(append(otherDataHash, dataHash[:]...))
-	checkHash(dataset.Hash(), datasetHash[:], "Dataset", t)
+	checkUnchanged(encodeOtherData(dataset, initialLength), otherData, "Other data", t)
 }
 
-func mockDescription() *modules.Description {
+func mockDescription(nonce int64) *modules.Description {
 	providerInfo := []byte(lorem.Sentence(10, 20))
 	dataInfo := []byte(lorem.Sentence(10, 20))
-	signature := crypto.Sign(requirerPrivKey, append(providerInfo, dataInfo...))
 	description := modules.Description{
 		ProviderInfo:    providerInfo,
 		DataInfo:        dataInfo,
-		Validator:       validatorPubKey,
-		Acceptor:        acceptorPubKey,
+		Validators:      [][]byte{validatorPubKey},
+		Acceptors:       [][]byte{acceptorPubKey},
 		Requirer:        requirerPubKey,
 		ValidatorAmount: modules.ToSats(1),
 		ProviderAmount:  modules.ToSats(1),
 		AcceptorAmount:  modules.ToSats(1),
 		MaxVersions:     4,
-		Signature:       signature,
+		Nonce:           nonce,
 	}
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
 	return &description
 }
 
@@ -74,11 +94,11 @@ func compareDescription(desc1, desc2 *modules.Description, t *testing.T) {
 	if bytes.Compare(desc1.DataInfo, desc2.DataInfo) != 0 {
 		t.Errorf("Corrupted data info")
 	}
-	if bytes.Compare(desc1.Validator, desc2.Validator) != 0 {
-		t.Errorf("Corrupted trusted validator")
+	if !reflect.DeepEqual(desc1.Validators, desc2.Validators) {
+		t.Errorf("Corrupted trusted validators")
 	}
-	if bytes.Compare(desc1.Acceptor, desc2.Acceptor) != 0 {
-		t.Errorf("Corrupted Acceptor")
+	if !reflect.DeepEqual(desc1.Acceptors, desc2.Acceptors) {
+		t.Errorf("Corrupted Acceptors")
 	}
 	if bytes.Compare(desc1.Requirer, desc2.Requirer) != 0 {
 		t.Errorf("Corrupted Requirer")
@@ -104,12 +124,11 @@ func TestAddValidation(t *testing.T) {
 
 func checkValidation(dataset *modules.Dataset, dataIndex int, zpk zpk, t *testing.T) {
 	dataLength, versionLength := dataLength(dataset, dataIndex)
-	dataHashL, dataHashR := dataHash(dataset, dataIndex)
-	otherVersionHash, _ := versionHash(dataset, dataIndex, versionLength) // since we add a new version, we will have only versions at the left
+	otherData := encodeOtherData(dataset, dataIndex)
+	otherVersions := encodeOtherVersions(dataset, dataIndex, versionLength)
 
-	validation := mockValidation(zpk)
+	validation := mockValidation(zpk, dataIndex, 0)
 	dataset.AddValidation(validation, dataIndex)
-	data := dataset.DataList[dataIndex]
 	version := dataset.DataList[dataIndex].VersionList[versionLength]
 
 	checkLength(dataset.DataList, dataLength, "Data list", t)
@@ -117,24 +136,19 @@ func checkValidation(dataset *modules.Dataset, dataIndex int, zpk zpk, t *testin
 	checkEmpty(version.AcceptedPayload, "Accepted payload", t)
 	checkEmpty(version.Payload, "Payload", t)
 	compareValidation(version.Validation, validation, t)
-	emptyAcceptedPayload := modules.AcceptedPayload{}
-	emptyPayload := modules.Payload{}
-	versionHash := sha256.Sum256(append(append(emptyAcceptedPayload.Hash(), emptyPayload.Hash()...), validation.Hash()...))
-	checkHash(version.Hash(), versionHash[:], "Version", t)
-	dataHash := sha256.Sum256(append(append(data.Description.Hash(), otherVersionHash...), versionHash[:]...))
-	checkHash(data.Hash(), dataHash[:], "Data", t)
-	datasetHash := sha256.Sum256(append(append(dataHashL, dataHash[:]...), dataHashR...))
-	checkHash(dataset.Hash(), datasetHash[:], "Dataset", t)
+	checkUnchanged(encodeOtherData(dataset, dataIndex), otherData, "Other data", t)
+	checkUnchanged(encodeOtherVersions(dataset, dataIndex, versionLength), otherVersions, "Other versions", t)
 }
 
-func mockValidation(zpk zpk) *modules.Validation {
+func mockValidation(zpk zpk, dataIndex int, nonce int64) *modules.Validation {
 	validationInfo := zpk.info
-	signature := crypto.Sign(validatorPrivKey, validationInfo[:])
 	validation := modules.Validation{
 		Info:          validationInfo[:],
 		ValidatorAddr: validatorPubKey,
-		Signature:     signature,
+		DataIndex:     dataIndex,
+		Nonce:         nonce,
 	}
+	validation.Signature = crypto.Sign(validatorPrivKey, validation.SignBytes(testChainID))
 	return &validation
 }
 
@@ -169,13 +183,12 @@ func TestAddPayload(t *testing.T) {
 
 func checkPayload(dataset *modules.Dataset, dataIndex, versionIndex int, zpk zpk, t *testing.T) {
 	dataLength, versionLength := dataLength(dataset, dataIndex)
-	dataHashL, dataHashR := dataHash(dataset, dataIndex)
-	versionHashL, versionHashR := versionHash(dataset, dataIndex, versionIndex)
+	otherData := encodeOtherData(dataset, dataIndex)
+	otherVersions := encodeOtherVersions(dataset, dataIndex, versionIndex)
 	initialVersion := dataset.DataList[dataIndex].VersionList[versionIndex]
 
-	payload := mockPayload(zpk)
+	payload := mockPayload(zpk, dataIndex, versionIndex, 0)
 	dataset.AddPayload(payload, dataIndex, versionIndex)
-	data := dataset.DataList[dataIndex]
 	version := dataset.DataList[dataIndex].VersionList[versionIndex]
 
 	checkLength(dataset.DataList, dataLength, "Data list", t)
@@ -183,24 +196,25 @@ func checkPayload(dataset *modules.Dataset, dataIndex, versionIndex int, zpk zpk
 	checkEmpty(version.AcceptedPayload, "Accepted payload", t)
 	comparePayload(version.Payload, payload, t)
 	compareValidation(version.Validation, initialVersion.Validation, t)
-	emptyAcceptedPayload := modules.AcceptedPayload{}
-	versionHash := sha256.Sum256(append(append(emptyAcceptedPayload.Hash(), payload.Hash()...), initialVersion.Validation.Hash()...))
-	checkHash(version.Hash(), versionHash[:], "Version", t)
-	dataHash := sha256.Sum256(append(append(append(data.Description.Hash(), versionHashL...), versionHash[:]...), versionHashR...))
-	checkHash(data.Hash(), dataHash[:], "Data", t)
-	datasetHash := sha256.Sum256(append(append(dataHashL, dataHash[:]...), dataHashR...))
-	checkHash(dataset.Hash(), datasetHash[:], "Dataset", t)
+	checkUnchanged(encodeOtherData(dataset, dataIndex), otherData, "Other data", t)
+	checkUnchanged(encodeOtherVersions(dataset, dataIndex, versionIndex), otherVersions, "Other versions", t)
 }
 
-func mockPayload(zpk zpk) *modules.Payload {
+// Commitment of the mock payloads and accepted payloads, to a plaintext their random data doesn't encrypt
+var mockCommitment = crypto.Commitment([]byte("Some plaintext data"))
+
+func mockPayload(zpk zpk, dataIndex, versionIndex int, nonce int64) *modules.Payload {
 	data := []byte(lorem.Sentence(10, 50))
-	signature := crypto.Sign(providerPrivKey, append(data, zpk.proof...))
 	payload := modules.Payload{
 		Data:         data,
+		Commitment:   mockCommitment,
 		Proof:        zpk.proof,
 		ProviderAddr: providerPubKey,
-		Signature:    signature,
+		DataIndex:    dataIndex,
+		VersionIndex: versionIndex,
+		Nonce:        nonce,
 	}
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
 	return &payload
 }
 
@@ -233,13 +247,12 @@ func TestAcceptPayload(t *testing.T) {
 
 func checkAcceptedPayload(dataset *modules.Dataset, dataIndex, versionIndex int, t *testing.T) {
 	dataLength, versionLength := dataLength(dataset, dataIndex)
-	dataHashL, dataHashR := dataHash(dataset, dataIndex)
-	versionHashL, versionHashR := versionHash(dataset, dataIndex, versionIndex)
+	otherData := encodeOtherData(dataset, dataIndex)
+	otherVersions := encodeOtherVersions(dataset, dataIndex, versionIndex)
 	initialVersion := dataset.DataList[dataIndex].VersionList[versionIndex]
 
-	acceptedPayload := mockAcceptedPayload()
+	acceptedPayload := mockAcceptedPayload(dataIndex, versionIndex, 0)
 	dataset.AcceptPayload(acceptedPayload, dataIndex, versionIndex)
-	data := dataset.DataList[dataIndex]
 	version := dataset.DataList[dataIndex].VersionList[versionIndex]
 
 	checkLength(dataset.DataList, dataLength, "Data list", t)
@@ -247,22 +260,21 @@ func checkAcceptedPayload(dataset *modules.Dataset, dataIndex, versionIndex int,
 	compareAcceptedPayload(version.AcceptedPayload, acceptedPayload, t)
 	comparePayload(version.Payload, initialVersion.Payload, t)
 	compareValidation(version.Validation, initialVersion.Validation, t)
-	versionHash := sha256.Sum256(append(append(acceptedPayload.Hash(), initialVersion.Payload.Hash()...), initialVersion.Validation.Hash()...))
-	checkHash(version.Hash(), versionHash[:], "Version", t)
-	dataHash := sha256.Sum256(append(append(append(data.Description.Hash(), versionHashL...), versionHash[:]...), versionHashR...))
-	checkHash(data.Hash(), dataHash[:], "Data", t)
-	datasetHash := sha256.Sum256(append(append(dataHashL, dataHash[:]...), dataHashR...))
-	checkHash(dataset.Hash(), datasetHash[:], "Dataset", t)
+	checkUnchanged(encodeOtherData(dataset, dataIndex), otherData, "Other data", t)
+	checkUnchanged(encodeOtherVersions(dataset, dataIndex, versionIndex), otherVersions, "Other versions", t)
 }
 
-func mockAcceptedPayload() *modules.AcceptedPayload {
+func mockAcceptedPayload(dataIndex, versionIndex int, nonce int64) *modules.AcceptedPayload {
 	data := []byte(lorem.Sentence(10, 50))
-	signature := crypto.Sign(acceptorPrivKey, data)
 	acceptedPayload := modules.AcceptedPayload{
 		Data:         data,
+		Commitment:   mockCommitment,
 		AcceptorAddr: acceptorPubKey,
-		Signature:    signature,
+		DataIndex:    dataIndex,
+		VersionIndex: versionIndex,
+		Nonce:        nonce,
 	}
+	acceptedPayload.Signature = crypto.Sign(acceptorPrivKey, acceptedPayload.SignBytes(testChainID))
 	return &acceptedPayload
 }
 
@@ -278,6 +290,403 @@ func compareAcceptedPayload(acceptedPayload1, acceptedPayload2 *modules.Accepted
 	}
 }
 
+// ------------------------------------------------------------------------------------------------------------------- //
+// COMMITMENT
+
+func TestCommitment(t *testing.T) {
+	dataset := modules.NewDataset(&modules.Dataset{}, initBalance())
+	_ = dataset.AddData(mockDescription(0))
+	_ = dataset.AddValidation(mockValidation(zpks[0], 0, 0), 0)
+	plaintext := []byte(lorem.Sentence(10, 20))
+	payload := mockPayload(zpks[0], 0, 0, 0)
+	payload.Data, _ = crypto.Encrypt(acceptorPubKey, plaintext)
+	payload.Commitment = nil
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	checkError(dataset.AddPayload(payload, 0, 0), modules.ErrMissingCommitment, t)
+	payload.Commitment = crypto.Commitment(plaintext)
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	if err := dataset.AddPayload(payload, 0, 0); err != nil {
+		t.Fatalf("Failed to add payload: %v", err)
+	}
+
+	acceptedPayload := mockAcceptedPayload(0, 0, 0)
+	acceptedPayload.Data, _ = crypto.Reencrypt(acceptorPrivKey, payload.Data, requirerPubKey)
+	acceptedPayload.Commitment = nil
+	acceptedPayload.Signature = crypto.Sign(acceptorPrivKey, acceptedPayload.SignBytes(testChainID))
+	checkError(dataset.AcceptPayload(acceptedPayload, 0, 0), modules.ErrMissingCommitment, t)
+	acceptedPayload.Commitment = crypto.Commitment([]byte("Some other data"))
+	acceptedPayload.Signature = crypto.Sign(acceptorPrivKey, acceptedPayload.SignBytes(testChainID))
+	checkError(dataset.AcceptPayload(acceptedPayload, 0, 0), modules.ErrCommitmentMismatch, t)
+	acceptedPayload.Commitment = payload.Commitment
+	acceptedPayload.Signature = crypto.Sign(acceptorPrivKey, acceptedPayload.SignBytes(testChainID))
+	if err := dataset.AcceptPayload(acceptedPayload, 0, 0); err != nil {
+		t.Fatalf("Failed to accept payload: %v", err)
+	}
+	decrypted, _ := crypto.Decrypt(requirerPrivKey, dataset.DataList[0].VersionList[0].AcceptedPayload.Data)
+	if !bytes.Equal(crypto.Commitment(decrypted), dataset.DataList[0].VersionList[0].AcceptedPayload.Commitment) {
+		t.Errorf("Accepted data doesn't match the commitment")
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// OFF-CHAIN CONTENT
+
+func TestContentReference(t *testing.T) {
+	dataset := modules.NewDataset(&modules.Dataset{}, initBalance())
+	_ = dataset.AddData(mockDescription(0))
+	_ = dataset.AddValidation(mockValidation(zpks[0], 0, 0), 0)
+	content, _ := crypto.Encrypt(acceptorPubKey, []byte(lorem.Sentence(10, 20)))
+	hash := sha256.Sum256(content)
+
+	payload := mockPayload(zpks[0], 0, 0, 0)
+	payload.ContentHash = hash[:]
+	payload.ContentSize = int64(len(content))
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	checkError(dataset.AddPayload(payload, 0, 0), modules.ErrInvalidContent, t)
+	payload.Data = nil
+	payload.ContentSize = modules.MaxContentSize + 1
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	checkError(dataset.AddPayload(payload, 0, 0), modules.ErrInvalidContent, t)
+	payload.ContentSize = int64(len(content))
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	if err := dataset.AddPayload(payload, 0, 0); err != nil {
+		t.Fatalf("Failed to add payload referencing content: %v", err)
+	}
+	if size, ok := dataset.ContentSize(hash[:]); !ok || size != int64(len(content)) {
+		t.Errorf("Referenced content not found")
+	}
+	if _, ok := dataset.ContentSize(make([]byte, sha256.Size)); ok {
+		t.Errorf("Unreferenced content found")
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// PROOF SCHEMES
+
+func TestSchnorrProof(t *testing.T) {
+	dataset := modules.NewDataset(&modules.Dataset{}, initBalance())
+	description := mockDescription(0)
+	description.ProofScheme = "unknown"
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	checkError(dataset.AddData(description), modules.ErrUnknownProofScheme, t)
+	description.ProofScheme = modules.ProofSchnorr
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	if err := dataset.AddData(description); err != nil {
+		t.Fatalf("Failed to add data with schnorr proofs: %v", err)
+	}
+
+	secret := zpks[0].secret
+	checkError(dataset.AddValidation(mockValidation(zpks[0], 0, 0), 0), modules.ErrInvalidProof, t)
+	validation := modules.Validation{Info: crypto.KnowledgePoint(secret), ValidatorAddr: validatorPubKey}
+	validation.Signature = crypto.Sign(validatorPrivKey, validation.SignBytes(testChainID))
+	if err := dataset.AddValidation(&validation, 0); err != nil {
+		t.Fatalf("Failed to add validation with schnorr info: %v", err)
+	}
+
+	proof, _ := crypto.ProveKnowledge(secret, providerPubKey)
+	payload := modules.Payload{Data: []byte(lorem.Sentence(10, 20)), Commitment: mockCommitment, Proof: proof, ProviderAddr: acceptorPubKey}
+	payload.Signature = crypto.Sign(acceptorPrivKey, payload.SignBytes(testChainID))
+	checkError(dataset.AddPayload(&payload, 0, 0), modules.ErrInvalidProof, t)
+	payload = modules.Payload{Data: []byte(lorem.Sentence(10, 20)), Commitment: mockCommitment, Proof: proof, ProviderAddr: providerPubKey}
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	if err := dataset.AddPayload(&payload, 0, 0); err != nil {
+		t.Errorf("Valid schnorr proof rejected: %v", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// ACCEPTOR THRESHOLD
+
+func TestAcceptorThreshold(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	description := mockDescription(0)
+	description.Validators = nil // anyone can validate
+	description.Acceptors = [][]byte{acceptorPubKey, validatorPubKey}
+	description.AcceptorThreshold = 2
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	if err := dataset.AddData(description); err != nil {
+		t.Fatalf("Failed to add data with acceptor threshold: %v", err)
+	}
+	validation := modules.Validation{Info: zpks[0].info, ValidatorAddr: requirerPubKey}
+	validation.Signature = crypto.Sign(requirerPrivKey, validation.SignBytes(testChainID))
+	if err := dataset.AddValidation(&validation, 0); err != nil {
+		t.Fatalf("Validation from anyone rejected: %v", err)
+	}
+	_ = dataset.AddPayload(mockPayload(zpks[0], 0, 0, 0), 0, 0)
+
+	users := make(map[string]int64)
+	for user, amount := range balance.Users {
+		users[user] = amount
+	}
+	if err := dataset.AcceptPayload(mockAcceptedPayload(0, 0, 0), 0, 0); err != nil {
+		t.Fatalf("Failed to accept payload: %v", err)
+	}
+	checkEmpty(dataset.DataList[0].VersionList[0].AcceptedPayload, "Accepted payload below threshold", t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(0, 0, 0), 0, 0), modules.ErrAcceptedExists, t)
+	unlisted := modules.AcceptedPayload{Commitment: mockCommitment, AcceptorAddr: providerPubKey}
+	unlisted.Signature = crypto.Sign(providerPrivKey, unlisted.SignBytes(testChainID))
+	checkError(dataset.AcceptPayload(&unlisted, 0, 0), modules.ErrNotApproved, t)
+	second := modules.AcceptedPayload{Commitment: mockCommitment, AcceptorAddr: validatorPubKey}
+	second.Signature = crypto.Sign(validatorPrivKey, second.SignBytes(testChainID))
+	if err := dataset.AcceptPayload(&second, 0, 0); err != nil {
+		t.Fatalf("Failed to accept payload: %v", err)
+	}
+
+	share := description.AcceptorAmount / 2
+	expected := map[string]int64{
+		hex.EncodeToString(requirerPubKey):  users[hex.EncodeToString(requirerPubKey)] + description.ValidatorAmount,
+		hex.EncodeToString(providerPubKey):  users[hex.EncodeToString(providerPubKey)] + description.ProviderAmount,
+		hex.EncodeToString(acceptorPubKey):  users[hex.EncodeToString(acceptorPubKey)] + share,
+		hex.EncodeToString(validatorPubKey): users[hex.EncodeToString(validatorPubKey)] + share,
+	}
+	for user, amount := range expected {
+		if balance.Users[user] != amount {
+			t.Errorf("Invalid reward split, expected %d, got %d", amount, balance.Users[user])
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// REJECTED PAYLOAD
+
+func TestRejectPayload(t *testing.T) {
+	dataset := mockDataset(true, true, true)
+	checkError(dataset.AddValidation(mockValidation(zpks[4], 0, 0), 0), modules.ErrMaxVersions, t)
+
+	rejected := mockRejectedPayload(requirerPubKey, requirerPrivKey, 0, 0, 0)
+	checkError(dataset.RejectPayload(rejected, 0, 0), modules.ErrNotApproved, t)
+	rejected = mockRejectedPayload(acceptorPubKey, acceptorPrivKey, 0, 0, 0)
+	checkError(dataset.RejectPayload(rejected, 0, 1), modules.ErrIndexMismatch, t)
+	if err := dataset.RejectPayload(rejected, 0, 0); err != nil {
+		t.Fatalf("Failed to reject payload: %v", err)
+	}
+	if dataset.DataList[0].VersionList[0].RejectedPayload.Reason != rejected.Reason {
+		t.Errorf("Rejected payload not stored in version")
+	}
+	checkError(dataset.RejectPayload(rejected, 0, 0), modules.ErrPayloadRejected, t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(0, 0, 0), 0, 0), modules.ErrPayloadRejected, t)
+	if err := dataset.AddValidation(mockValidation(zpks[4], 0, 0), 0); err != nil {
+		t.Errorf("Rejected version slot not freed: %v", err)
+	}
+	if err := dataset.AcceptPayload(mockAcceptedPayload(0, 1, 0), 0, 1); err != nil {
+		t.Errorf("Failed to accept payload after rejection: %v", err)
+	}
+	checkError(dataset.RejectPayload(mockRejectedPayload(acceptorPubKey, acceptorPrivKey, 0, 1, 0), 0, 1), modules.ErrAcceptedExists, t)
+
+	description := mockDescription(0)
+	description.Acceptors = nil
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	_ = dataset.AddData(description)
+	dataIndex := len(dataset.DataList) - 1
+	_ = dataset.AddValidation(mockValidation(zpks[5], dataIndex, 0), dataIndex)
+	_ = dataset.AddPayload(mockPayload(zpks[5], dataIndex, 0, 0), dataIndex, 0)
+	checkError(dataset.RejectPayload(mockRejectedPayload(acceptorPubKey, acceptorPrivKey, dataIndex, 0, 0), dataIndex, 0), modules.ErrNotApproved, t)
+	if err := dataset.RejectPayload(mockRejectedPayload(requirerPubKey, requirerPrivKey, dataIndex, 0, 0), dataIndex, 0); err != nil {
+		t.Errorf("Requirer failed to reject payload without acceptors: %v", err)
+	}
+}
+
+func mockRejectedPayload(acceptor, acceptorKey []byte, dataIndex, versionIndex int, nonce int64) *modules.RejectedPayload {
+	rejectedPayload := modules.RejectedPayload{
+		Reason:       lorem.Sentence(5, 10),
+		AcceptorAddr: acceptor,
+		DataIndex:    dataIndex,
+		VersionIndex: versionIndex,
+		Nonce:        nonce,
+	}
+	rejectedPayload.Signature = crypto.Sign(acceptorKey, rejectedPayload.SignBytes(testChainID))
+	return &rejectedPayload
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// DISPUTE
+
+func TestDispute(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	provider := hex.EncodeToString(providerPubKey)
+	description := mockDescription(0)
+	description.Arbiter = validatorPubKey
+	description.ChallengeWindow = 10
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	dataset.BeginBlock(1, 0)
+	if err := dataset.AddData(description); err != nil {
+		t.Fatalf("Failed to add data with challenge window: %v", err)
+	}
+	for versionIndex := 0; versionIndex < 4; versionIndex++ {
+		_ = dataset.AddValidation(mockValidation(zpks[versionIndex], 0, 0), 0)
+		_ = dataset.AddPayload(mockPayload(zpks[versionIndex], 0, versionIndex, 0), 0, versionIndex)
+		_ = dataset.AcceptPayload(mockAcceptedPayload(0, versionIndex, 0), 0, versionIndex)
+	}
+	if balance.Users[provider] != initialUsers[provider] {
+		t.Errorf("Reward paid during challenge window")
+	}
+
+	checkError(dataset.OpenDispute(mockDispute(acceptorPubKey, acceptorPrivKey, 0, 0), 0, 0), modules.ErrNotApproved, t)
+	checkError(dataset.OpenDispute(mockDispute(requirerPubKey, requirerPrivKey, 0, 0), 0, 1), modules.ErrIndexMismatch, t)
+	if err := dataset.OpenDispute(mockDispute(requirerPubKey, requirerPrivKey, 0, 0), 0, 0); err != nil {
+		t.Fatalf("Failed to open dispute: %v", err)
+	}
+	checkError(dataset.OpenDispute(mockDispute(requirerPubKey, requirerPrivKey, 0, 0), 0, 0), modules.ErrDisputeExists, t)
+
+	dataset.BeginBlock(11, 0)
+	if balance.Users[provider] != initialUsers[provider]+3*description.ProviderAmount {
+		t.Errorf("Undisputed rewards not released at the end of the challenge window")
+	}
+	checkError(dataset.OpenDispute(mockDispute(requirerPubKey, requirerPrivKey, 0, 1), 0, 1), modules.ErrChallengeClosed, t)
+	checkError(dataset.AddValidation(mockValidation(zpks[4], 0, 0), 0), modules.ErrMaxVersions, t)
+
+	checkError(dataset.ResolveDispute(mockResolution(acceptorPubKey, acceptorPrivKey, true, 0, 0), 0, 0), modules.ErrNotApproved, t)
+	checkError(dataset.ResolveDispute(mockResolution(validatorPubKey, validatorPrivKey, true, 0, 1), 0, 0), modules.ErrIndexMismatch, t)
+	checkError(dataset.ResolveDispute(mockResolution(validatorPubKey, validatorPrivKey, true, 0, 1), 0, 1), modules.ErrMissingDispute, t)
+	if err := dataset.ResolveDispute(mockResolution(validatorPubKey, validatorPrivKey, true, 0, 0), 0, 0); err != nil {
+		t.Fatalf("Failed to resolve dispute: %v", err)
+	}
+	checkError(dataset.ResolveDispute(mockResolution(validatorPubKey, validatorPrivKey, false, 0, 0), 0, 0), modules.ErrDisputeResolved, t)
+	if balance.Users[provider] != initialUsers[provider]+3*description.ProviderAmount {
+		t.Errorf("Refunded reward paid")
+	}
+	if err := dataset.AddValidation(mockValidation(zpks[4], 0, 0), 0); err != nil {
+		t.Errorf("Refunded version slot not freed: %v", err)
+	}
+}
+
+func mockDispute(requirer, requirerKey []byte, dataIndex, versionIndex int) *modules.Dispute {
+	dispute := modules.Dispute{
+		Reason:       lorem.Sentence(5, 10),
+		Requirer:     requirer,
+		DataIndex:    dataIndex,
+		VersionIndex: versionIndex,
+	}
+	dispute.Signature = crypto.Sign(requirerKey, dispute.SignBytes(testChainID))
+	return &dispute
+}
+
+func mockResolution(arbiter, arbiterKey []byte, refund bool, dataIndex, versionIndex int) *modules.Resolution {
+	resolution := modules.Resolution{
+		Refund:       refund,
+		Reason:       lorem.Sentence(5, 10),
+		Arbiter:      arbiter,
+		DataIndex:    dataIndex,
+		VersionIndex: versionIndex,
+	}
+	resolution.Signature = crypto.Sign(arbiterKey, resolution.SignBytes(testChainID))
+	return &resolution
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// CLOSE DATA
+
+func TestCloseData(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	_ = dataset.AddData(mockDescription(0))
+	_ = dataset.AddData(mockDescription(0))
+	_ = dataset.AddValidation(mockValidation(zpks[0], 0, 0), 0)
+	requirer := hex.EncodeToString(requirerPubKey)
+
+	checkError(dataset.CloseData(mockCloseData(requirerPubKey, requirerPrivKey, 0, 0), 1), modules.ErrIndexMismatch, t)
+	checkError(dataset.CloseData(mockCloseData(acceptorPubKey, acceptorPrivKey, 0, 0), 0), modules.ErrNotApproved, t)
+	_ = dataset.AddPayload(mockPayload(zpks[0], 0, 0, 0), 0, 0)
+	checkError(dataset.CloseData(mockCloseData(requirerPubKey, requirerPrivKey, 0, 0), 0), modules.ErrAwaitingAcceptance, t)
+	_ = dataset.RejectPayload(mockRejectedPayload(acceptorPubKey, acceptorPrivKey, 0, 0, 0), 0, 0)
+	if err := dataset.CloseData(mockCloseData(requirerPubKey, requirerPrivKey, 0, 0), 0); err != nil {
+		t.Fatalf("Failed to close data: %v", err)
+	}
+	if !dataset.DataList[0].Closed || dataset.DataList[1].Closed {
+		t.Errorf("Data not marked as closed")
+	}
+	if balance.Users[requirer] != initialUsers[requirer]-dataset.DataList[1].Description.MaxVersions*modules.ToSats(3) {
+		t.Errorf("Unconfirmed rewards not refunded")
+	}
+	checkError(dataset.CloseData(mockCloseData(requirerPubKey, requirerPrivKey, 0, 0), 0), modules.ErrDataClosed, t)
+	checkError(dataset.AddValidation(mockValidation(zpks[1], 0, 0), 0), modules.ErrDataClosed, t)
+	checkError(dataset.AddPayload(mockPayload(zpks[0], 0, 0, 0), 0, 0), modules.ErrDataClosed, t)
+}
+
+func mockCloseData(requirer, requirerKey []byte, dataIndex int, nonce int64) *modules.CloseData {
+	closeData := modules.CloseData{
+		Requirer:  requirer,
+		DataIndex: dataIndex,
+		Nonce:     nonce,
+	}
+	closeData.Signature = crypto.Sign(requirerKey, closeData.SignBytes(testChainID))
+	return &closeData
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// EXPIRY
+
+func TestExpiry(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	requirer := hex.EncodeToString(requirerPubKey)
+	_ = dataset.AddData(mockExpiringDescription(5, 0))
+	_ = dataset.AddData(mockExpiringDescription(0, 1000))
+
+	dataset.BeginBlock(4, 999)
+	if err := dataset.AddValidation(mockValidation(zpks[0], 0, 0), 0); err != nil {
+		t.Errorf("Validation rejected before expiry: %v", err)
+	}
+	if err := dataset.AddValidation(mockValidation(zpks[1], 1, 0), 1); err != nil {
+		t.Errorf("Validation rejected before expiry: %v", err)
+	}
+	if dataset.DataList[0].Closed || dataset.DataList[1].Closed {
+		t.Errorf("Data closed before expiry")
+	}
+
+	_ = balance.Events()
+	dataset.BeginBlock(5, 1000)
+	if !dataset.DataList[0].Closed || !dataset.DataList[1].Closed {
+		t.Errorf("Expired data not closed")
+	}
+	if balance.Users[requirer] != initialUsers[requirer] {
+		t.Errorf("Expired rewards not refunded")
+	}
+	if events := balance.Events(); len(events) != 4 {
+		t.Errorf("Expected close reward and expire data events, got %v", events)
+	}
+	checkError(dataset.AddValidation(mockValidation(zpks[2], 0, 0), 0), modules.ErrDataExpired, t)
+	checkError(dataset.AddPayload(mockPayload(zpks[1], 1, 0, 0), 1, 0), modules.ErrDataExpired, t)
+	checkError(dataset.AddData(mockExpiringDescription(5, 0)), modules.ErrInvalidExpiry, t)
+}
+
+func mockExpiringDescription(height, time int64) *modules.Description {
+	description := mockDescription(0)
+	description.ExpiryHeight = height
+	description.ExpiryTime = time
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	return description
+}
+
+// ------------------------------------------------------------------------------------------------------------------- //
+// INVALID INDEXES
+
+func TestInvalidIndexes(t *testing.T) {
+	dataset := mockDataset(true, true, false)
+	checkError(dataset.AddValidation(mockValidation(zpks[0], len(dataset.DataList), 0), len(dataset.DataList)), modules.ErrUnknownData, t)
+	checkError(dataset.AddValidation(mockValidation(zpks[0], -1, 0), -1), modules.ErrUnknownData, t)
+	checkError(dataset.AddPayload(mockPayload(zpks[0], 0, len(dataset.DataList[0].VersionList), 0), 0, len(dataset.DataList[0].VersionList)), modules.ErrUnknownVersion, t)
+	checkError(dataset.AddPayload(mockPayload(zpks[0], 10, 0, 0), 10, 0), modules.ErrUnknownData, t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(0, -1, 0), 0, -1), modules.ErrUnknownVersion, t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(1, 0, 0), 1, 0), modules.ErrUnknownVersion, t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(0, 0, 0), 0, 0), modules.ErrMissingPayload, t)
+
+	// messages signed for a data or version can't be replayed on another one
+	checkError(dataset.AddValidation(mockValidation(zpks[0], 0, 0), 2), modules.ErrIndexMismatch, t)
+	checkError(dataset.AddPayload(mockPayload(zpks[0], 0, 0, 0), 0, 1), modules.ErrIndexMismatch, t)
+	checkError(dataset.AddPayload(mockPayload(zpks[0], 0, 0, 0), 2, 0), modules.ErrIndexMismatch, t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(0, 0, 0), 2, 0), modules.ErrIndexMismatch, t)
+	checkError(dataset.RejectPayload(mockRejectedPayload(acceptorPubKey, acceptorPrivKey, 0, 0, 0), 0, 1), modules.ErrIndexMismatch, t)
+}
+
+func checkError(err error, expected *modules.Error, t *testing.T) {
+	if modules.ErrorCode(err) != expected.Code {
+		t.Errorf("Expected error %q, got %v", expected.Message, err)
+	}
+}
+
 // ------------------------------------------------------------------------------------------------------------------- //
 // TESTING UTILITIES
 
@@ -292,14 +701,14 @@ func mockDataset(data, validation, payload bool) *modules.Dataset {
 	if data {
 		for zpkIndex, dataIndex := range zpkToData {
 			for len(dataset.DataList) <= dataIndex {
-				dataset.AddData(mockDescription())
+				dataset.AddData(mockDescription(0))
 				versionIndex = 0
 			}
 			if validation {
-				dataset.AddValidation(mockValidation(zpks[zpkIndex]), dataIndex)
+				dataset.AddValidation(mockValidation(zpks[zpkIndex], dataIndex, 0), dataIndex)
 			}
 			if payload {
-				dataset.AddPayload(mockPayload(zpks[zpkIndex]), dataIndex, versionIndex)
+				dataset.AddPayload(mockPayload(zpks[zpkIndex], dataIndex, versionIndex, 0), dataIndex, versionIndex)
 				versionIndex++
 			}
 		}
@@ -313,26 +722,28 @@ func dataLength(dataset *modules.Dataset, dataIndex int) (dataListLength, versio
 	return
 }
 
-func dataHash(dataset *modules.Dataset, dataIndex int) (dataHashL, dataHashR []byte) {
+// Encodes every data but the one at dataIndex, to check they aren't changed
+func encodeOtherData(dataset *modules.Dataset, dataIndex int) []byte {
+	var encoded []byte
 	for i, data := range dataset.DataList {
-		if i < dataIndex {
-			dataHashL = append(dataHashL, data.Hash()...)
-		} else if i > dataIndex {
-			dataHashR = append(dataHashR, data.Hash()...)
+		if i != dataIndex {
+			bytes, _ := json.Marshal(data)
+			encoded = append(encoded, bytes...)
 		}
 	}
-	return
+	return encoded
 }
 
-func versionHash(dataset *modules.Dataset, dataIndex, versionIndex int) (versionHashL, versionHashR []byte) {
+// Encodes every version of the data but the one at versionIndex, to check they aren't changed
+func encodeOtherVersions(dataset *modules.Dataset, dataIndex, versionIndex int) []byte {
+	var encoded []byte
 	for i, version := range dataset.DataList[dataIndex].VersionList {
-		if i < versionIndex {
-			versionHashL = append(versionHashL, version.Hash()...)
-		} else if i > versionIndex {
-			versionHashR = append(versionHashR, version.Hash()...)
+		if i != versionIndex {
+			bytes, _ := json.Marshal(version)
+			encoded = append(encoded, bytes...)
 		}
 	}
-	return
+	return encoded
 }
 
 func checkLength(list interface{}, validLength int, descriptor string, t *testing.T) {
@@ -354,6 +765,12 @@ func checkNil(element interface{}, descriptor string, t *testing.T) {
 	}
 }
 
+func checkUnchanged(encoded, initialEncoded []byte, descriptor string, t *testing.T) {
+	if bytes.Compare(encoded, initialEncoded) != 0 {
+		t.Errorf(descriptor + " changed")
+	}
+}
+
 func checkHash(hash, validHash []byte, descriptor string, t *testing.T) {
 	if bytes.Compare(hash, validHash) != 0 {
 		t.Errorf(descriptor + ": invalid hash")
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"dbc-node/app"
+	"dbc-node/crypto"
+	"dbc-node/modules"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/tendermint/tendermint/abci/types"
+	dbm "github.com/tendermint/tm-db"
+	"testing"
+	"time"
+)
+
+func TestInitChain(t *testing.T) {
+	genesis := mockGenesisState()
+	genesis.Data = []*modules.Description{mockDescription(0)}
+	txFee := int64(modules.TxFee * 2)
+	genesis.Params.TxFee = &txFee
+	request := mockRequestInitChain()
+	request.AppStateBytes, _ = json.Marshal(genesis)
+	request.Validators = genesis.Validators()
+
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	response := dbc.InitChain(request)
+	if len(response.Validators) != 1 || hex.EncodeToString(response.Validators[0].PubKey.Data) != hex.EncodeToString(stakePubKey) ||
+		response.Validators[0].Power != genValidators[hex.EncodeToString(stakePubKey)] {
+		t.Errorf("Invalid validator set returned: %v", response.Validators)
+	}
+	if len(dbc.New.Dataset.DataList) != 1 || len(dbc.Check.Dataset.DataList) != 1 {
+		t.Fatalf("Genesis data not loaded")
+	}
+	requirer := hex.EncodeToString(requirerPubKey)
+	if dbc.New.Balance.Users[requirer] >= genUsers[requirer] {
+		t.Errorf("Genesis data reward not escrowed")
+	}
+	if dbc.New.Balance.ChainID != testChainID || *dbc.New.Balance.Params.TxFee != txFee {
+		t.Errorf("Chain ID and params not loaded")
+	}
+	before := dbc.New.Balance.Users[hex.EncodeToString(validatorPubKey)]
+	_ = dbc.DeliverTx(mockRequestDeliverTx("TxTransfer", 0))
+	if dbc.New.Balance.Users[hex.EncodeToString(validatorPubKey)] != before-modules.ToSats(2)-txFee {
+		t.Errorf("Genesis transaction fee not charged")
+	}
+}
+
+func TestZeroFee(t *testing.T) {
+	genesis := mockGenesisState()
+	txFee := int64(0)
+	genesis.Params.TxFee = &txFee
+	request := mockRequestInitChain()
+	request.AppStateBytes, _ = json.Marshal(genesis)
+	request.Validators = genesis.Validators()
+
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(request)
+	before := dbc.New.Balance.Users[hex.EncodeToString(validatorPubKey)]
+	if response := dbc.DeliverTx(mockRequestDeliverTx("TxTransfer", 0)); response.Code != 0 {
+		t.Fatalf("Valid transaction rejected: " + response.Log)
+	}
+	if dbc.New.Balance.Users[hex.EncodeToString(validatorPubKey)] != before-modules.ToSats(2) {
+		t.Errorf("Fee charged with a zero genesis transaction fee")
+	}
+}
+
+func TestInvalidGenesis(t *testing.T) {
+	invalid := map[string]func(genesis *app.GenesisState, request *types.RequestInitChain){
+		"invalid account": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			genesis.Accounts = append(genesis.Accounts, app.GenesisAccount{PubKey: "00", Balance: 1})
+		},
+		"duplicate account": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			genesis.Accounts = append(genesis.Accounts, genesis.Accounts[0])
+		},
+		"negative balance": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			genesis.Accounts[0].Balance = -1
+		},
+		"supply exceeded": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			genesis.Accounts[0].Balance = modules.SatsSupply
+		},
+		"invalid validator": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			genesis.Stakes[0].Validator = hex.EncodeToString(requirerPubKey)
+		},
+		"validator without stake": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			request.Validators = append(genesis.Validators(), types.Ed25519ValidatorUpdate(make([]byte, 32), 1))
+		},
+		"power isn't stake": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			request.Validators = genesis.Validators()
+			request.Validators[0].Power++
+		},
+		"negative fee": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			txFee := int64(-1)
+			genesis.Params.TxFee = &txFee
+		},
+		"unsigned data": func(genesis *app.GenesisState, request *types.RequestInitChain) {
+			description := mockDescription(0)
+			description.Signature = nil
+			genesis.Data = append(genesis.Data, description)
+		},
+	}
+	for descriptor, change := range invalid {
+		genesis := mockGenesisState()
+		request := mockRequestInitChain()
+		change(genesis, &request)
+		request.AppStateBytes, _ = json.Marshal(genesis)
+		if !initChainPanics(request) {
+			t.Errorf("%s: chain initialized", descriptor)
+		}
+	}
+	if !initChainPanics(types.RequestInitChain{ChainId: testChainID, AppStateBytes: []byte("{")}) {
+		t.Errorf("Chain initialized from invalid JSON")
+	}
+}
+
+func initChainPanics(request types.RequestInitChain) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(request)
+	return false
+}
+
+func TestGenTx(t *testing.T) {
+	genesis := mockGenesisState()
+	validator := make([]byte, 32)
+	validator[0] = 1
+	requirer := hex.EncodeToString(requirerPubKey)
+	amount := genUsers[requirer] / 2
+
+	checkError(genesis.AddGenTx(testChainID, &app.GenTx{}), modules.ErrInvalidGenesis, t)
+	checkError(genesis.AddGenTx("other", mockGenTx(requirerPubKey, requirerPrivKey, validator, amount)), modules.ErrInvalidGenesis, t)
+	checkError(genesis.AddGenTx(testChainID, mockGenTx(requirerPubKey, requirerPrivKey, validator, genUsers[requirer]+1)), modules.ErrInvalidGenesis, t)
+	checkError(genesis.AddGenTx(testChainID, mockGenTx(pubKey, privKey, validator, 1)), modules.ErrInvalidGenesis, t)
+	checkError(genesis.AddGenTx(testChainID, &app.GenTx{Stake: mockStake(requirerPubKey, requirerPrivKey, validator, nil, amount, 0)}), modules.ErrInvalidGenesis, t)
+	genTx := mockGenTx(requirerPubKey, requirerPrivKey, validator, amount)
+	if err := genesis.AddGenTx(testChainID, genTx); err != nil {
+		t.Fatalf("Failed to add gentx: %v", err)
+	}
+	if err := genTx.Stake.Verify(testChainID); err == nil {
+		t.Errorf("Gentx stake valid as a TxStake")
+	}
+	genTx = mockGenTx(requirerPubKey, requirerPrivKey, stakePubKey, amount)
+	if err := genesis.AddGenTx(testChainID, genTx); err != nil {
+		t.Fatalf("Failed to add gentx to a genesis stake: %v", err)
+	}
+	stakes := make(map[string]int64)
+	for _, stake := range genesis.Stakes {
+		stakes[stake.Validator] = stake.Amount
+	}
+	if stakes[hex.EncodeToString(validator)] != amount || stakes[hex.EncodeToString(stakePubKey)] != genValidators[hex.EncodeToString(stakePubKey)]+amount {
+		t.Errorf("Gentx stakes not added: %v", genesis.Stakes)
+	}
+	for _, account := range genesis.Accounts {
+		if account.PubKey == requirer && account.Balance != genUsers[requirer]-2*amount {
+			t.Errorf("Gentx stakes not paid by the account")
+		}
+	}
+
+	if err := genesis.Check(testChainID, genesis.Validators()); err != nil {
+		t.Errorf("Collected genesis refused: %v", err)
+	}
+	checkError(genesis.Check(testChainID, genesis.Validators()[:1]), modules.ErrInvalidGenesis, t)
+	genesis.Data = []*modules.Description{mockDescription(0)}
+	checkError(genesis.Check("other", nil), modules.ErrInvalidGenesis, t)
+}
+
+func mockGenTx(user, userKey, validator []byte, amount int64) *app.GenTx {
+	stake := &modules.Stake{
+		User:      user,
+		Validator: validator,
+		Amount:    amount,
+		Time:      time.Now().Unix(),
+	}
+	stake.Signature = crypto.Sign(userKey, stake.GenTxSignBytes(testChainID))
+	return &app.GenTx{Stake: stake}
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"dbc-node/crypto"
+	"dbc-node/keys"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keystore")
+	defer os.RemoveAll(dir)
+	store, err := keys.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatorKey, _, _ := crypto.GenerateKeys(crypto.KeyEd25519)
+	if _, err := store.Add("requirer", crypto.KeySecp256k1, requirerPrivKey, "passphrase"); err != nil {
+		t.Fatalf("Failed to add secp256k1 key: %v", err)
+	}
+	if _, err := store.Add("validator", crypto.KeyEd25519, validatorKey, "passphrase"); err != nil {
+		t.Fatalf("Failed to add ed25519 key: %v", err)
+	}
+	if _, err := store.Add("requirer", crypto.KeySecp256k1, providerPrivKey, "passphrase"); !errors.Is(err, keys.ErrExists) {
+		t.Errorf("Key overwritten: %v", err)
+	}
+	if _, err := store.Add("../provider", crypto.KeySecp256k1, providerPrivKey, "passphrase"); !errors.Is(err, keys.ErrInvalidName) {
+		t.Errorf("Key added outside the keystore: %v", err)
+	}
+	if _, err := store.Add("provider", crypto.KeySecp256k1, providerPrivKey, "short"); !errors.Is(err, keys.ErrWeak) {
+		t.Errorf("Key added with a short passphrase: %v", err)
+	}
+	if _, err := store.Add("provider", crypto.KeySecp256k1, make([]byte, 32), "passphrase"); !errors.Is(err, crypto.ErrInvalidKey) {
+		t.Errorf("Invalid key added: %v", err)
+	}
+
+	list, err := store.List()
+	if err != nil || len(list) != 2 || list[0].Name != "requirer" || list[1].Type != crypto.KeyEd25519 {
+		t.Fatalf("Failed to list keys: %v", err)
+	}
+	if !bytes.Equal(list[0].PubKey, requirerPubKey) {
+		t.Errorf("Invalid public key listed")
+	}
+	key, privKey, err := store.PrivKey("validator", "passphrase")
+	if err != nil || !bytes.Equal(privKey, validatorKey) || !crypto.VerifyED(key.PubKey, []byte("message"), crypto.SignED(privKey, []byte("message"))) {
+		t.Errorf("Failed to unlock ed25519 key: %v", err)
+	}
+	if _, _, err := store.PrivKey("requirer", "wrong passphrase"); !errors.Is(err, keys.ErrPassphrase) {
+		t.Errorf("Key unlocked with a wrong passphrase: %v", err)
+	}
+	if _, err := store.Get("provider"); !errors.Is(err, keys.ErrNotFound) {
+		t.Errorf("Missing key found: %v", err)
+	}
+
+	file := filepath.Join(dir, "requirer.json")
+	encoded, _ := ioutil.ReadFile(file)
+	tampered := strings.Replace(string(encoded), crypto.KeySecp256k1, crypto.KeyEd25519, 1)
+	_ = ioutil.WriteFile(file, []byte(tampered), 0600)
+	if _, _, err := store.PrivKey("requirer", "passphrase"); err == nil {
+		t.Errorf("Tampered key unlocked")
+	}
+	_ = ioutil.WriteFile(file, encoded, 0600)
+
+	if err := store.Delete("requirer", "wrong passphrase"); !errors.Is(err, keys.ErrPassphrase) {
+		t.Errorf("Key deleted with a wrong passphrase: %v", err)
+	}
+	if err := store.Delete("requirer", "passphrase"); err != nil {
+		t.Errorf("Failed to delete key: %v", err)
+	}
+	if list, _ := store.List(); len(list) != 1 {
+		t.Errorf("Deleted key listed")
+	}
+}
+
+func TestKeystoreSeed(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keystore")
+	defer os.RemoveAll(dir)
+	store, _ := keys.NewStore(dir)
+	if _, err := store.Derive("requirer", 0, 0, "passphrase"); !errors.Is(err, keys.ErrNoSeed) {
+		t.Errorf("Key derived without a seed: %v", err)
+	}
+	seed, _ := crypto.MnemonicSeed(strings.Repeat("abandon ", 11)+"about", "")
+	if err := store.AddSeed(seed, "short"); !errors.Is(err, keys.ErrWeak) {
+		t.Errorf("Seed added with a short passphrase: %v", err)
+	}
+	if err := store.AddSeed(seed, "passphrase"); err != nil || !store.HasSeed() {
+		t.Fatalf("Failed to add seed: %v", err)
+	}
+	if err := store.AddSeed(seed, "passphrase"); !errors.Is(err, keys.ErrSeedExists) {
+		t.Errorf("Seed overwritten: %v", err)
+	}
+	if unsealed, err := store.Seed("passphrase"); err != nil || !bytes.Equal(unsealed, seed) {
+		t.Errorf("Failed to unseal seed: %v", err)
+	}
+	if _, err := store.Seed("wrong passphrase"); !errors.Is(err, keys.ErrPassphrase) {
+		t.Errorf("Seed unsealed with a wrong passphrase: %v", err)
+	}
+
+	if _, err := store.Derive("requirer", 0, 0, "wrong passphrase"); !errors.Is(err, keys.ErrPassphrase) {
+		t.Errorf("Key derived with a wrong passphrase: %v", err)
+	}
+	key, err := store.Derive("requirer", 0, 0, "passphrase")
+	_, pubKey, _ := crypto.DeriveKeys(seed, crypto.AccountPath(0, 0))
+	if err != nil || !bytes.Equal(key.PubKey, pubKey) || key.Path != crypto.AccountPath(0, 0) {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	if _, err := store.Derive("requirer", 0, 1, "passphrase"); !errors.Is(err, keys.ErrExists) {
+		t.Errorf("Derived key overwritten: %v", err)
+	}
+	if _, err := store.Derive("request", 0, 4, "passphrase"); err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	if index, err := store.NextIndex(0); err != nil || index != 5 {
+		t.Errorf("Invalid next index %d: %v", index, err)
+	}
+	if index, err := store.NextIndex(1); err != nil || index != 0 {
+		t.Errorf("Invalid next index of a new account %d: %v", index, err)
+	}
+
+	list, err := store.List()
+	if err != nil || len(list) != 2 || list[0].Path != crypto.AccountPath(0, 4) {
+		t.Fatalf("Failed to list derived keys: %v", err)
+	}
+	_, privKey, err := store.PrivKey("requirer", "passphrase")
+	if expected, _, _ := crypto.DeriveKeys(seed, crypto.AccountPath(0, 0)); err != nil || !bytes.Equal(privKey, expected) {
+		t.Errorf("Failed to unlock derived key: %v", err)
+	}
+	file := filepath.Join(dir, "requirer.json")
+	encoded, _ := ioutil.ReadFile(file)
+	tampered := strings.Replace(string(encoded), crypto.AccountPath(0, 0), crypto.AccountPath(0, 9), 1)
+	_ = ioutil.WriteFile(file, []byte(tampered), 0600)
+	if _, _, err := store.PrivKey("requirer", "passphrase"); err == nil {
+		t.Errorf("Key with a tampered path unlocked")
+	}
+}
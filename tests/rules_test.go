@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"dbc-node/crypto"
+	"dbc-node/modules"
+	"encoding/hex"
+	"testing"
+)
+
+var receiptSchema = []byte(`{"type": "object", "required": ["document", "status"], "properties": {"status": {"enum": ["paid", "due"]}}}`)
+
+func TestRevealPayload(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	_ = dataset.RegisterSchema(mockSchema("receipt", receiptSchema))
+	provider := hex.EncodeToString(providerPubKey)
+	acceptor := hex.EncodeToString(acceptorPubKey)
+	document := []byte("receipt #42")
+	documentHash := sha256.Sum256(document)
+
+	description := mockRulesDescription([]modules.Rule{{Op: modules.RuleHash, Field: "document", Value: []byte("short")}})
+	checkError(dataset.AddData(description), modules.ErrInvalidRule, t)
+	description = mockRulesDescription([]modules.Rule{{Op: "regexp", Field: "document"}})
+	checkError(dataset.AddData(description), modules.ErrInvalidRule, t)
+	description = mockRulesDescription([]modules.Rule{
+		{Op: modules.RuleSchema},
+		{Op: modules.RuleHash, Field: "document", Value: documentHash[:]},
+		{Op: modules.RuleEquals, Field: "status", Value: []byte(`"paid"`)},
+		{Op: modules.RuleSignature, Field: "document", Key: validatorPubKey},
+	})
+	if err := dataset.AddData(description); err != nil {
+		t.Fatalf("Failed to add data with rules: %v", err)
+	}
+	_ = dataset.AddData(mockDescription(0))
+	plaintext := []byte(`{"document": "receipt #42", "status": "paid"}`)
+	for dataIndex := 0; dataIndex < 2; dataIndex++ {
+		_ = dataset.AddValidation(mockValidation(zpks[dataIndex], dataIndex, 0), dataIndex)
+		_ = dataset.AddPayload(mockCommittedPayload(zpks[dataIndex], plaintext, dataIndex, 0), dataIndex, 0)
+	}
+	certificate := crypto.Sign(validatorPrivKey, document)
+
+	checkError(dataset.RevealPayload(mockReveal(plaintext, description.Rules, 1, 0, certificate), 1, 0), modules.ErrNoRules, t)
+	checkError(dataset.RevealPayload(mockReveal([]byte(`{}`), description.Rules, 0, 0, certificate), 0, 0), modules.ErrCommitmentMismatch, t)
+	checkError(dataset.RevealPayload(mockReveal(plaintext, description.Rules, 0, 0), 0, 0), modules.ErrRuleFailed, t)
+	checkError(dataset.RevealPayload(mockReveal(plaintext, description.Rules, 0, 0, crypto.Sign(acceptorPrivKey, document)), 0, 0), modules.ErrRuleFailed, t)
+	fields := mockReveal(plaintext, description.Rules[1:], 0, 0, certificate)
+	checkError(dataset.RevealPayload(fields, 0, 0), modules.ErrInvalidReveal, t)
+	front := mockReveal(plaintext, description.Rules, 0, 0, certificate)
+	front.Sender = providerPubKey
+	front.Signature = crypto.Sign(providerPrivKey, front.SignBytes(testChainID))
+	checkError(dataset.RevealPayload(front, 0, 0), modules.ErrNotApproved, t)
+	if balance.Users[provider] != initialUsers[provider] {
+		t.Errorf("Reward paid before the rules passed")
+	}
+	if err := dataset.RevealPayload(mockReveal(plaintext, description.Rules, 0, 0, certificate), 0, 0); err != nil {
+		t.Fatalf("Failed to reveal payload: %v", err)
+	}
+	if balance.Users[provider] != initialUsers[provider]+description.ProviderAmount ||
+		balance.Users[acceptor] != initialUsers[acceptor]+description.AcceptorAmount {
+		t.Errorf("Provider and acceptor amounts not paid to the provider and the acceptor revealing the payload")
+	}
+	checkError(dataset.RevealPayload(mockReveal(plaintext, description.Rules, 0, 0, certificate), 0, 0), modules.ErrAcceptedExists, t)
+	checkError(dataset.AcceptPayload(mockAcceptedPayload(0, 0, 0), 0, 0), modules.ErrAcceptedExists, t)
+	checkError(dataset.RejectPayload(mockRejectedPayload(acceptorPubKey, acceptorPrivKey, 0, 0, 0), 0, 0), modules.ErrAcceptedExists, t)
+
+	_ = dataset.AddValidation(mockValidation(zpks[2], 0, 0), 0)
+	due := []byte(`{"document": "receipt #42", "status": "due"}`)
+	_ = dataset.AddPayload(mockCommittedPayload(zpks[2], due, 0, 1), 0, 1)
+	checkError(dataset.RevealPayload(mockReveal(due, description.Rules, 0, 1, certificate), 0, 1), modules.ErrRuleFailed, t)
+}
+
+func TestRevealFields(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	_ = dataset.RegisterSchema(mockSchema("receipt", receiptSchema))
+	provider := hex.EncodeToString(providerPubKey)
+	documentHash := sha256.Sum256([]byte("receipt #42"))
+	description := mockRulesDescription([]modules.Rule{
+		{Op: modules.RuleHash, Field: "document", Value: documentHash[:]},
+		{Op: modules.RuleEquals, Field: "status", Value: []byte(`"paid"`)},
+	})
+	description.Acceptors = nil
+	description.AcceptorThreshold = 2
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	checkError(dataset.AddData(description), modules.ErrInvalidAmount, t)
+	description.AcceptorThreshold = 0
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	if err := dataset.AddData(description); err != nil {
+		t.Fatalf("Failed to add data with rules: %v", err)
+	}
+	plaintext := []byte(`{"status": "paid", "document": "receipt #42", "amount": {"value": 1200, "salt": "x8Kq2"}}`)
+	_ = dataset.AddValidation(mockValidation(zpks[0], 0, 0), 0)
+	_ = dataset.AddPayload(mockCommittedPayload(zpks[0], plaintext, 0, 0), 0, 0)
+
+	whole := mockReveal(plaintext, []modules.Rule{{Op: modules.RuleSchema}}, 0, 0)
+	checkError(dataset.RevealPayload(whole, 0, 0), modules.ErrInvalidReveal, t)
+	if err := dataset.RevealPayload(mockReveal(plaintext, description.Rules, 0, 0), 0, 0); err != nil {
+		t.Fatalf("Failed to reveal the fields of the payload: %v", err)
+	}
+	for _, revealed := range dataset.DataList[0].VersionList[0].Reveal.Fields {
+		if (len(revealed.Value) > 0) != (revealed.Name != "amount") {
+			t.Errorf("Field %s revealed against the rules", revealed.Name)
+		}
+	}
+	if balance.Users[provider] != initialUsers[provider]+description.ProviderAmount+description.AcceptorAmount {
+		t.Errorf("Acceptor share not paid to the provider without acceptors")
+	}
+}
+
+func mockRulesDescription(rules []modules.Rule) *modules.Description {
+	description := mockDescription(0)
+	description.DataSchema = "receipt"
+	description.Rules = rules
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	return description
+}
+
+func mockCommittedPayload(zpk zpk, plaintext []byte, dataIndex, versionIndex int) *modules.Payload {
+	payload := mockPayload(zpk, dataIndex, versionIndex, 0)
+	payload.Data, _ = crypto.Encrypt(acceptorPubKey, plaintext)
+	payload.Commitment, _ = modules.FieldsCommitment(plaintext)
+	payload.Signature = crypto.Sign(providerPrivKey, payload.SignBytes(testChainID))
+	return payload
+}
+
+func mockReveal(plaintext []byte, rules []modules.Rule, dataIndex, versionIndex int, certificates ...[]byte) *modules.Reveal {
+	reveal, _ := modules.NewReveal(plaintext, rules)
+	reveal.Certificates = certificates
+	reveal.Sender = acceptorPubKey
+	reveal.DataIndex = dataIndex
+	reveal.VersionIndex = versionIndex
+	reveal.Signature = crypto.Sign(acceptorPrivKey, reveal.SignBytes(testChainID))
+	return reveal
+}
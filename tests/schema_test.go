@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"dbc-node/app"
+	"dbc-node/crypto"
+	"dbc-node/messages"
+	"dbc-node/modules"
+	"dbc-node/schema"
+	"encoding/json"
+	"errors"
+	dbm "github.com/tendermint/tm-db"
+	"strings"
+	"testing"
+)
+
+var invoiceSchema = []byte(`{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "invoice",
+	"type": "object",
+	"required": ["number", "amount", "lines"],
+	"additionalProperties": false,
+	"properties": {
+		"number": {"type": "string", "pattern": "^INV-[0-9]+$"},
+		"amount": {"type": "number", "minimum": 0},
+		"currency": {"enum": ["EUR", "USD"]},
+		"lines": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"required": ["item", "quantity"],
+				"properties": {
+					"item": {"type": "string", "minLength": 1},
+					"quantity": {"type": "integer", "minimum": 1}
+				}
+			}
+		}
+	}
+}`)
+
+var providerSchema = []byte(`{"type": "object", "required": ["country"], "properties": {"country": {"type": "string", "maxLength": 2}}}`)
+
+func TestSchemaValidation(t *testing.T) {
+	invoice, err := schema.Compile(invoiceSchema)
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+	valid := []string{
+		`{"number": "INV-1", "amount": 12.5, "lines": [{"item": "book", "quantity": 2}]}`,
+		`{"number": "INV-2", "amount": 0, "currency": "EUR", "lines": [{"item": "pen", "quantity": 1, "color": "red"}]}`,
+	}
+	for _, document := range valid {
+		if err := invoice.Validate([]byte(document)); err != nil {
+			t.Errorf("Valid document refused: %v", err)
+		}
+	}
+	invalid := map[string]string{
+		`{"number": "INV-1", "amount": 1}`:                                                  "missing property",
+		`{"number": "1", "amount": 1, "lines": [{"item": "a", "quantity": 1}]}`:             "pattern",
+		`{"number": "INV-1", "amount": -1, "lines": [{"item": "a", "quantity": 1}]}`:        "minimum",
+		`{"number": "INV-1", "amount": "1", "lines": [{"item": "a", "quantity": 1}]}`:       "type",
+		`{"number": "INV-1", "amount": 1, "lines": []}`:                                     "min items",
+		`{"number": "INV-1", "amount": 1, "lines": [{"item": "a", "quantity": 1.5}]}`:       "integer",
+		`{"number": "INV-1", "amount": 1, "lines": [{"item": "", "quantity": 1}]}`:          "min length",
+		`{"number": "INV-1", "amount": 1, "currency": "GBP", "lines": []}`:                  "enum",
+		`{"number": "INV-1", "amount": 1, "lines": [{"item": "a", "quantity": 1}], "x": 1}`: "additional property",
+		`{"number": "INV-1"} {}`: "trailing data",
+		`not json`:               "not json",
+	}
+	for document, descriptor := range invalid {
+		if err := invoice.Validate([]byte(document)); !errors.Is(err, schema.ErrMismatch) {
+			t.Errorf("%s: invalid document accepted: %v", descriptor, err)
+		}
+	}
+	definitions := map[string]string{
+		`[]`:                              "not an object",
+		`{"type": "decimal"}`:             "unknown type",
+		`{"oneOf": [{"type": "string"}]}`: "unsupported keyword",
+		`{"pattern": "("}`:                "invalid pattern",
+		`{"minLength": -1}`:               "negative count",
+		`{"properties": {"a": 1}}`:        "invalid property",
+	}
+	for definition, descriptor := range definitions {
+		if _, err := schema.Compile([]byte(definition)); !errors.Is(err, schema.ErrInvalid) {
+			t.Errorf("%s: invalid schema compiled: %v", descriptor, err)
+		}
+	}
+}
+
+func TestSchemaDecrypt(t *testing.T) {
+	invoice, _ := schema.Compile(invoiceSchema)
+	plaintext := []byte(`{"number": "INV-7", "amount": 3, "lines": [{"item": "lamp", "quantity": 1}]}`)
+	ciphertext, _ := crypto.Encrypt(acceptorPubKey, plaintext)
+	if decrypted, err := invoice.Decrypt(acceptorPrivKey, ciphertext); err != nil || string(decrypted) != string(plaintext) {
+		t.Errorf("Failed to decrypt and validate: %v", err)
+	}
+	ciphertext, _ = crypto.Encrypt(acceptorPubKey, []byte(`{"number": "INV-7"}`))
+	if _, err := invoice.Decrypt(acceptorPrivKey, ciphertext); !errors.Is(err, schema.ErrMismatch) {
+		t.Errorf("Invalid plaintext accepted: %v", err)
+	}
+}
+
+func TestRegisterSchema(t *testing.T) {
+	balance := initBalance()
+	dataset := modules.NewDataset(&modules.Dataset{}, balance)
+	if err := dataset.RegisterSchema(mockSchema("invoice", invoiceSchema)); err != nil {
+		t.Fatalf("Failed to register schema: %v", err)
+	}
+	checkError(dataset.RegisterSchema(mockSchema("invoice", providerSchema)), modules.ErrSchemaExists, t)
+	checkError(dataset.RegisterSchema(mockSchema("Invoice/2", providerSchema)), modules.ErrInvalidSchema, t)
+	checkError(dataset.RegisterSchema(mockSchema("any", []byte(`{"anyOf": []}`))), modules.ErrInvalidSchema, t)
+	for _, pattern := range []string{`^(?=INV)`, `^(?!INV)`, `(?<=INV-)[0-9]+`, `(?<!X)-`, `^(.)\\1$`, `\\k<n>`} {
+		definition := []byte(`{"type": "string", "pattern": "` + pattern + `"}`)
+		err := dataset.RegisterSchema(mockSchema("pattern", definition))
+		checkError(err, modules.ErrInvalidSchema, t)
+		if err == nil || !strings.Contains(err.Error(), "RE2") {
+			t.Errorf("Pattern %s refused without naming RE2: %v", pattern, err)
+		}
+	}
+	_ = dataset.RegisterSchema(mockSchema("provider", providerSchema))
+
+	description := mockSchemaDescription("invoice", "unknown", []byte(`{"country": "IT"}`))
+	checkError(dataset.AddData(description), modules.ErrUnknownSchema, t)
+	description = mockSchemaDescription("invoice", "provider", []byte(`{"country": "Italy"}`))
+	checkError(dataset.AddData(description), modules.ErrSchemaMismatch, t)
+	description = mockSchemaDescription("invoice", "provider", []byte(`{"country": "IT"}`))
+	if err := dataset.AddData(description); err != nil {
+		t.Fatalf("Failed to add data with schemas: %v", err)
+	}
+	dataSchema, err := dataset.Schema(dataset.DataList[0].Description.DataSchema)
+	if err != nil {
+		t.Fatalf("Failed to get data schema: %v", err)
+	}
+	if err := dataSchema.Validate([]byte(`{"number": "INV-1", "amount": 1, "lines": [{"item": "a", "quantity": 1}]}`)); err != nil {
+		t.Errorf("Registered schema refused a valid document: %v", err)
+	}
+
+	next := modules.NewDataset(dataset, modules.NewBalance(balance))
+	if len(next.Schemas) != 2 || next.Schemas["invoice"] != dataset.Schemas["invoice"] {
+		t.Errorf("Schemas not carried to the next dataset")
+	}
+}
+
+func TestQuerySchema(t *testing.T) {
+	dbc, _ := app.NewDataBlockChain(dbm.NewMemDB())
+	_ = dbc.InitChain(mockRequestInitChain())
+	missing, _ := json.Marshal(messages.Transaction{TxType: messages.TxRegisterSchema})
+	if response := dbc.CheckTx(mockRequestCheckTx(missing)); response.Code != modules.ErrMissingContent.Code {
+		t.Errorf("Transaction without schema checked: %d %s", response.Code, response.Log)
+	}
+	deliverTx := dbc.DeliverTx(mockRequestDeliverTx(messages.TxRegisterSchema, 0))
+	if deliverTx.Code != 0 || len(deliverTx.Events) != 1 || deliverTx.Events[0].Type != modules.EventRegisterSchema {
+		t.Fatalf("Failed to deliver schema: %d %s", deliverTx.Code, deliverTx.Log)
+	}
+	checkAttribute(deliverTx.Events[0], modules.AttributeSchema, "invoice", t)
+	_ = dbc.Commit()
+
+	query := dbc.Query(mockRequestQuery(messages.Path(messages.PathSchema, "invoice"), true))
+	var registered modules.Schema
+	if err := json.Unmarshal(query.Value, &registered); err != nil || query.Code != 0 || query.Proof == nil {
+		t.Fatalf("Failed to query schema: %d %s", query.Code, query.Log)
+	}
+	if _, err := schema.Compile(registered.Definition); err != nil || registered.ID != "invoice" {
+		t.Errorf("Invalid schema returned: %v", err)
+	}
+	if query := dbc.Query(mockRequestQuery(messages.Path(messages.PathSchema, "unknown"), false)); query.Code != modules.ErrNotFound.Code {
+		t.Errorf("Unknown schema found: %d %s", query.Code, query.Log)
+	}
+	var schemas []*modules.Schema
+	query = dbc.Query(mockRequestQuery(messages.PathSchemas, false))
+	if err := json.Unmarshal(query.Value, &messages.Page{Items: &schemas}); err != nil || len(schemas) != 1 {
+		t.Errorf("Failed to query schemas: %v", err)
+	}
+}
+
+func mockSchema(id string, definition []byte) *modules.Schema {
+	registered := modules.Schema{
+		ID:         id,
+		Definition: definition,
+		Owner:      requirerPubKey,
+	}
+	registered.Signature = crypto.Sign(requirerPrivKey, registered.SignBytes(testChainID))
+	return &registered
+}
+
+func mockSchemaDescription(dataSchema, providerSchema string, providerInfo []byte) *modules.Description {
+	description := mockDescription(0)
+	description.DataSchema = dataSchema
+	description.ProviderSchema = providerSchema
+	description.ProviderInfo = providerInfo
+	description.Signature = crypto.Sign(requirerPrivKey, description.SignBytes(testChainID))
+	return description
+}
@@ -8,11 +8,13 @@ import (
 	lorem "github.com/drhodes/golorem"
 	"github.com/tendermint/tendermint/crypto/ed25519"
 	"io/ioutil"
+	"os"
 	"os/exec"
 )
 
 const (
 	// General
+	testChainID   = "test-chain"
 	testDirectory = "testdata/"
 	ecParamFile   = testDirectory + "ecparam.pem"
 	// Crypto
@@ -60,20 +62,21 @@ var (
 )
 
 func init() {
+	_ = os.MkdirAll(testDirectory, 0755)
 	// Crypto
 	_ = exec.Command("./key.sh", ecParamFile, privKeyFile, pubKeyFile).Run()
 	_ = ioutil.WriteFile(messageFile, []byte("Some message inside a file"), 0644)
 	_ = exec.Command("./sign.sh", privKeyFile, signatureFile, messageFile).Run()
-	privKey, pubKey = crypto.LoadKeys(privKeyFile, pubKeyFile)
+	privKey, pubKey, _ = crypto.LoadKeys(privKeyFile, pubKeyFile)
 	// Modules
 	_ = exec.Command("./key.sh", ecParamFile, requirerPrivKeyFile, requirerPubKeyFile).Run()
-	requirerPrivKey, requirerPubKey = crypto.LoadKeys(requirerPrivKeyFile, requirerPubKeyFile)
+	requirerPrivKey, requirerPubKey, _ = crypto.LoadKeys(requirerPrivKeyFile, requirerPubKeyFile)
 	_ = exec.Command("./key.sh", ecParamFile, validatorPrivKeyFile, validatorPubKeyFile).Run()
-	validatorPrivKey, validatorPubKey = crypto.LoadKeys(validatorPrivKeyFile, validatorPubKeyFile)
+	validatorPrivKey, validatorPubKey, _ = crypto.LoadKeys(validatorPrivKeyFile, validatorPubKeyFile)
 	_ = exec.Command("./key.sh", ecParamFile, providerPrivKeyFile, providerPubKeyFile).Run()
-	providerPrivKey, providerPubKey = crypto.LoadKeys(providerPrivKeyFile, providerPubKeyFile)
+	providerPrivKey, providerPubKey, _ = crypto.LoadKeys(providerPrivKeyFile, providerPubKeyFile)
 	_ = exec.Command("./key.sh", ecParamFile, acceptorPrivKeyFile, acceptorPubKeyFile).Run()
-	acceptorPrivKey, acceptorPubKey = crypto.LoadKeys(acceptorPrivKeyFile, acceptorPubKeyFile)
+	acceptorPrivKey, acceptorPubKey, _ = crypto.LoadKeys(acceptorPrivKeyFile, acceptorPubKeyFile)
 	// Dataset
 	for i := 0; i < 10; i++ {
 		secret := []byte(lorem.Sentence(0, 5))